@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedCredential is the result of a verified basic auth credential.
+type CachedCredential struct {
+	UserID string
+	Roles  []string
+
+	username  string
+	expiresAt time.Time
+}
+
+// CredentialCache keeps recently verified basic auth credentials in memory so that every request
+// does not pay a password hash comparison. Entries are keyed by a keyed hash of the credentials,
+// the password is never stored. The key is random per process.
+type CredentialCache struct {
+	mu         sync.Mutex
+	key        []byte
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]CachedCredential
+}
+
+// NewCredentialCache returns a cache holding at most maxEntries credentials for ttl.
+// Zero ttl or maxEntries disables caching, as does a nil cache.
+func NewCredentialCache(ttl time.Duration, maxEntries int) *CredentialCache {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+
+	return &CredentialCache{
+		key:        key,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    map[string]CachedCredential{},
+	}
+}
+
+// Get returns the cached credential if username and password were verified recently.
+func (c *CredentialCache) Get(username string, password string) (CachedCredential, bool) {
+	if c == nil {
+		return CachedCredential{}, false
+	}
+
+	key := c.hash(username, password)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return CachedCredential{}, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return CachedCredential{}, false
+	}
+
+	return entry, true
+}
+
+// Put caches verified credentials of the user.
+func (c *CredentialCache) Put(username string, password string, userId string, roles []string) {
+	if c == nil || c.ttl <= 0 || c.maxEntries <= 0 {
+		return
+	}
+
+	key := c.hash(username, password)
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		//still full, an arbitrary entry makes room.
+		for k := range c.entries {
+			if len(c.entries) < c.maxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = CachedCredential{
+		UserID:    userId,
+		Roles:     roles,
+		username:  username,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+// InvalidateUser removes cached credentials of the user, e.g. after a password change.
+func (c *CredentialCache) InvalidateUser(userId string) {
+	c.invalidate(func(entry CachedCredential) bool { return entry.UserID == userId })
+}
+
+// InvalidateUsername removes cached credentials of the username in any case, e.g. when the account is locked.
+func (c *CredentialCache) InvalidateUsername(username string) {
+	c.invalidate(func(entry CachedCredential) bool { return strings.EqualFold(entry.username, username) })
+}
+
+func (c *CredentialCache) invalidate(match func(entry CachedCredential) bool) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if match(entry) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *CredentialCache) hash(username string, password string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCredentialCache(t *testing.T) {
+	cache := NewCredentialCache(time.Minute, 10)
+	cache.Put("User@Example.com", "password", "1", []string{RoleUser})
+
+	if _, ok := cache.Get("User@Example.com", "password"); !ok {
+		t.Fatal("Get() of cached credentials = false")
+	}
+	if _, ok := cache.Get("User@Example.com", "other"); ok {
+		t.Error("Get() with another password = true")
+	}
+
+	cache.InvalidateUsername("user@example.com")
+	if _, ok := cache.Get("User@Example.com", "password"); ok {
+		t.Error("Get() after InvalidateUsername() in another case = true")
+	}
+}
+
+func TestDisabledCredentialCache(t *testing.T) {
+	for name, cache := range map[string]*CredentialCache{
+		"nil":       nil,
+		"zero ttl":  NewCredentialCache(0, 10),
+		"zero size": NewCredentialCache(time.Minute, 0),
+	} {
+		cache.Put("user@example.com", "password", "1", nil)
+		if _, ok := cache.Get("user@example.com", "password"); ok {
+			t.Errorf("Get() of %s cache = true", name)
+		}
+		cache.InvalidateUser("1")
+	}
+}
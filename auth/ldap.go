@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrInvalidCredentials is returned by a Directory when the username or password is wrong.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Directory authenticates users against an external user directory.
+type Directory interface {
+	// Name identifies the directory, it is the Directory of its entries.
+	Name() string
+	Authenticate(username string, password string) (*DirectoryEntry, error)
+}
+
+// DirectoryEntry is an authenticated user of a directory. Directory and DN identify the user.
+type DirectoryEntry struct {
+	Directory string
+	DN        string
+	Email     string
+	Name      string
+	Surname   string
+}
+
+// LDAPDirectory authenticates with search and bind: the entry of the user is searched with the
+// service account, then the password is checked by binding as that entry.
+type LDAPDirectory struct {
+	URL          string
+	StartTLS     bool
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter has a %s for the escaped username, e.g. (mail=%s) or (uid=%s).
+	UserFilter string
+	// attributes of the entry copied to the local user.
+	EmailAttribute   string
+	NameAttribute    string
+	SurnameAttribute string
+}
+
+// LDAPDirectoryFromEnv returns the directory configured with LDAP_URL, LDAP_STARTTLS, LDAP_BIND_DN, LDAP_BIND_PASSWORD,
+// LDAP_BASE_DN, LDAP_USER_FILTER and the LDAP_*_ATTRIBUTE settings. It returns nil if LDAP_URL is not set.
+func LDAPDirectoryFromEnv() *LDAPDirectory {
+	if os.Getenv("LDAP_URL") == "" {
+		return nil
+	}
+
+	return &LDAPDirectory{
+		URL:              os.Getenv("LDAP_URL"),
+		StartTLS:         os.Getenv("LDAP_STARTTLS") == "true",
+		BindDN:           os.Getenv("LDAP_BIND_DN"),
+		BindPassword:     os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:           os.Getenv("LDAP_BASE_DN"),
+		UserFilter:       utils.StringFromEnv("LDAP_USER_FILTER", "(mail=%s)"),
+		EmailAttribute:   utils.StringFromEnv("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		NameAttribute:    utils.StringFromEnv("LDAP_NAME_ATTRIBUTE", "givenName"),
+		SurnameAttribute: utils.StringFromEnv("LDAP_SURNAME_ATTRIBUTE", "sn"),
+	}
+}
+
+// Name returns the URL of the server.
+func (d *LDAPDirectory) Name() string {
+	return d.URL
+}
+
+// Authenticate finds the entry of username and binds with its password.
+func (d *LDAPDirectory) Authenticate(username string, password string) (*DirectoryEntry, error) {
+	//a bind with an empty password is an unauthenticated bind which succeeds on most servers.
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := ldap.DialURL(d.URL, ldap.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetTimeout(10 * time.Second)
+
+	if d.StartTLS {
+		address, err := url.Parse(d.URL)
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: address.Hostname()}); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.BindDN != "" {
+		if err := conn.Bind(d.BindDN, d.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 10, false,
+		fmt.Sprintf(d.UserFilter, ldap.EscapeFilter(username)),
+		[]string{d.EmailAttribute, d.NameAttribute, d.SurnameAttribute},
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+	//username must identify exactly one entry.
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap bind: %w", err)
+	}
+
+	email := entry.GetAttributeValue(d.EmailAttribute)
+	if email == "" {
+		return nil, errors.New("ldap entry " + entry.DN + " has no " + d.EmailAttribute)
+	}
+
+	return &DirectoryEntry{
+		Directory: d.Name(),
+		DN:        entry.DN,
+		Email:     email,
+		Name:      entry.GetAttributeValue(d.NameAttribute),
+		Surname:   entry.GetAttributeValue(d.SurnameAttribute),
+	}, nil
+}
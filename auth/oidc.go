@@ -0,0 +1,337 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// keys of the provider are fetched again for an unknown key id at most once in this interval.
+const oidcKeyRefreshInterval = time.Minute
+
+// OIDCProvider is an OpenID Connect provider used with the authorization code flow and PKCE.
+// Endpoints and signing keys are discovered from the issuer on first use.
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	client *http.Client
+
+	mu            sync.Mutex
+	discovered    bool
+	authURL       string
+	tokenURL      string
+	jwksURL       string
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+// OIDCClaims are the claims of an ID token used to link the external identity to a user.
+type OIDCClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// OIDCProviderFromEnv returns the provider configured with OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET,
+// OIDC_REDIRECT_URL and OIDC_SCOPES. It returns nil if OIDC_ISSUER is not set.
+func OIDCProviderFromEnv() *OIDCProvider {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+
+	scopes := []string{"openid", "email", "profile"}
+	if value := os.Getenv("OIDC_SCOPES"); value != "" {
+		scopes = strings.Fields(strings.ReplaceAll(value, ",", " "))
+	}
+
+	return &OIDCProvider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       scopes,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// NewPKCE returns a code verifier and its S256 code challenge.
+func NewPKCE() (string, string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", err
+	}
+
+	verifier := base64.RawURLEncoding.EncodeToString(bytes)
+	sum := sha256.Sum256([]byte(verifier))
+
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// AuthCodeURL returns the URL of the provider the user is redirected to for login.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.authURL, "?") {
+		separator = "&"
+	}
+
+	return p.authURL + separator + query.Encode(), nil
+}
+
+// Exchange redeems the authorization code with its code verifier and returns the verified claims of the ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*OIDCClaims, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	var response struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.getJSON(request, &response); err != nil && response.Error == "" {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("token endpoint: %s %s", response.Error, response.ErrorDescription)
+	}
+	if response.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return p.verifyIDToken(ctx, response.IDToken, nonce)
+}
+
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, idToken string, nonce string) (*OIDCClaims, error) {
+	var claims OIDCClaims
+
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}))
+	_, err := parser.ParseWithClaims(idToken, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if !claims.VerifyIssuer(p.Issuer, true) && !claims.VerifyIssuer(p.Issuer+"/", true) {
+		return nil, errors.New("id token is issued by another issuer")
+	}
+	if !claims.VerifyAudience(p.ClientID, true) {
+		return nil, errors.New("id token is issued for another client")
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("id token has no expiry")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("id token nonce does not match")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+
+	return &claims, nil
+}
+
+// discover reads the endpoints of the provider from its discovery document.
+func (p *OIDCProvider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovered {
+		return nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return err
+	}
+
+	var document struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(request, &document); err != nil {
+		return fmt.Errorf("oidc discovery: %w", err)
+	}
+
+	if strings.TrimSuffix(document.Issuer, "/") != p.Issuer {
+		return errors.New("oidc discovery: issuer " + document.Issuer + " does not match " + p.Issuer)
+	}
+	if document.AuthorizationEndpoint == "" || document.TokenEndpoint == "" || document.JWKSURI == "" {
+		return errors.New("oidc discovery: missing endpoints")
+	}
+
+	p.authURL = document.AuthorizationEndpoint
+	p.tokenURL = document.TokenEndpoint
+	p.jwksURL = document.JWKSURI
+	p.discovered = true
+
+	return nil
+}
+
+// key returns the signing key by id. Keys are fetched again when the provider rotates them.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetchedAt) < oidcKeyRefreshInterval {
+		return nil, errors.New("unknown signing key " + kid)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	//providers with a single key may not send a key id.
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, nil
+		}
+	}
+
+	return nil, errors.New("unknown signing key " + kid)
+}
+
+func (p *OIDCProvider) fetchKeys(ctx context.Context) (map[string]interface{}, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(request, &set); err != nil {
+		return nil, fmt.Errorf("oidc keys: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		switch jwk.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch jwk.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	return keys, nil
+}
+
+// getJSON sends the request and decodes the JSON response. The body is decoded for error responses too.
+func (p *OIDCProvider) getJSON(request *http.Request, value interface{}) error {
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	decodeError := json.NewDecoder(response.Body).Decode(value)
+	if response.StatusCode != http.StatusOK {
+		return errors.New(request.URL.String() + " responded " + response.Status)
+	}
+
+	return decodeError
+}
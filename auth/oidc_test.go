@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// fakeProvider is an OpenID Connect provider issuing codes for a PKCE code challenge and signed ID tokens.
+type fakeProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+	issuer string
+
+	mu         sync.Mutex
+	challenges map[string]string
+	claims     map[string]OIDCClaims
+	codes      int
+	keyFetches int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &fakeProvider{key: key, kid: "key-1", challenges: map[string]string{}, claims: map[string]OIDCClaims{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.issuer,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.keyFetches++
+		p.mu.Unlock()
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": p.kid,
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		code := r.PostForm.Get("code")
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+
+		p.mu.Lock()
+		challenge, ok := p.challenges[code]
+		claims := p.claims[code]
+		delete(p.challenges, code)
+		p.mu.Unlock()
+
+		if !ok || r.PostForm.Get("grant_type") != "authorization_code" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(t, jwt.SigningMethodRS256, claims)})
+	})
+
+	p.server = httptest.NewServer(mux)
+	p.issuer = p.server.URL
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+// code returns an authorization code which is redeemed with the verifier of challenge for an ID token with claims.
+func (p *fakeProvider) code(challenge string, claims OIDCClaims) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.codes++
+	code := "code-" + strconv.Itoa(p.codes)
+	p.challenges[code] = challenge
+	p.claims[code] = claims
+	return code
+}
+
+func (p *fakeProvider) sign(t *testing.T, method jwt.SigningMethod, claims OIDCClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = p.kid
+
+	var key interface{} = p.key
+	if method == jwt.SigningMethodHS256 {
+		key = []byte("secret")
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// claims returns valid claims of an ID token for client with nonce.
+func (p *fakeProvider) validClaims(nonce string) OIDCClaims {
+	return OIDCClaims{
+		Email:         "user@example.com",
+		EmailVerified: true,
+		Nonce:         nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.issuer,
+			Subject:   "subject-1",
+			Audience:  jwt.ClaimStrings{"client"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+}
+
+func (p *fakeProvider) client() *OIDCProvider {
+	return &OIDCProvider{
+		Issuer:      p.issuer,
+		ClientID:    "client",
+		RedirectURL: "http://localhost/callback",
+		Scopes:      []string{"openid", "email"},
+		client:      p.server.Client(),
+	}
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	provider := newFakeProvider(t)
+
+	redirect, err := provider.client().AuthCodeURL(context.Background(), "state", "nonce", "challenge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := address.Scheme + "://" + address.Host + address.Path; got != provider.server.URL+"/authorize" {
+		t.Errorf("AuthCodeURL() endpoint = %q, want the discovered authorization endpoint", got)
+	}
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             "client",
+		"redirect_uri":          "http://localhost/callback",
+		"scope":                 "openid email",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        "challenge",
+		"code_challenge_method": "S256",
+	}
+	for key, value := range want {
+		if got := address.Query().Get(key); got != value {
+			t.Errorf("AuthCodeURL() %s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	tests := []struct {
+		name     string
+		document map[string]string
+		ok       bool
+	}{
+		{"issuer with trailing slash", map[string]string{"issuer": "{issuer}/", "authorization_endpoint": "a", "token_endpoint": "t", "jwks_uri": "j"}, true},
+		{"other issuer", map[string]string{"issuer": "https://attacker.example.com", "authorization_endpoint": "a", "token_endpoint": "t", "jwks_uri": "j"}, false},
+		{"missing endpoint", map[string]string{"issuer": "{issuer}", "authorization_endpoint": "a", "jwks_uri": "j"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				document := map[string]string{}
+				for key, value := range test.document {
+					document[key] = strings.ReplaceAll(value, "{issuer}", server.URL)
+				}
+				json.NewEncoder(w).Encode(document)
+			}))
+			defer server.Close()
+
+			provider := &OIDCProvider{Issuer: server.URL, client: server.Client()}
+			if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "challenge"); (err == nil) != test.ok {
+				t.Errorf("AuthCodeURL() error = %v, want ok %v", err, test.ok)
+			}
+		})
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	provider := newFakeProvider(t)
+
+	tests := []struct {
+		name   string
+		claims func(OIDCClaims) OIDCClaims
+		// verifier changes the code verifier sent to the token endpoint.
+		verifier func(string) string
+		nonce    string
+		ok       bool
+	}{
+		{name: "valid", ok: true},
+		{name: "issuer with trailing slash", claims: func(c OIDCClaims) OIDCClaims { c.Issuer += "/"; return c }, ok: true},
+		{name: "audience among others", claims: func(c OIDCClaims) OIDCClaims { c.Audience = jwt.ClaimStrings{"other", "client"}; return c }, ok: true},
+		{name: "other audience", claims: func(c OIDCClaims) OIDCClaims { c.Audience = jwt.ClaimStrings{"other"}; return c }},
+		{name: "other issuer", claims: func(c OIDCClaims) OIDCClaims { c.Issuer = "https://attacker.example.com"; return c }},
+		{name: "other nonce", nonce: "other"},
+		{name: "missing nonce", claims: func(c OIDCClaims) OIDCClaims { c.Nonce = ""; return c }},
+		{name: "expired", claims: func(c OIDCClaims) OIDCClaims {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+			return c
+		}},
+		{name: "no expiry", claims: func(c OIDCClaims) OIDCClaims { c.ExpiresAt = nil; return c }},
+		{name: "no subject", claims: func(c OIDCClaims) OIDCClaims { c.Subject = ""; return c }},
+		{name: "wrong code verifier", verifier: func(v string) string { return v + "x" }},
+		{name: "no code verifier", verifier: func(string) string { return "" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier, challenge, err := NewPKCE()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims := provider.validClaims("nonce")
+			if test.claims != nil {
+				claims = test.claims(claims)
+			}
+			code := provider.code(challenge, claims)
+
+			if test.verifier != nil {
+				verifier = test.verifier(verifier)
+			}
+			nonce := "nonce"
+			if test.nonce != "" {
+				nonce = test.nonce
+			}
+
+			got, err := provider.client().Exchange(context.Background(), code, verifier, nonce)
+			if (err == nil) != test.ok {
+				t.Fatalf("Exchange() error = %v, want ok %v", err, test.ok)
+			}
+			if test.ok && (got.Subject != "subject-1" || got.Email != "user@example.com" || !got.EmailVerified) {
+				t.Errorf("Exchange() claims = %+v", got)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifyIDTokenKeys(t *testing.T) {
+	provider := newFakeProvider(t)
+	client := provider.client()
+	if err := client.discover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.verifyIDToken(context.Background(), provider.sign(t, jwt.SigningMethodHS256, provider.validClaims("nonce")), "nonce"); err == nil {
+		t.Error("verifyIDToken() accepted a token signed with HS256")
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, provider.validClaims("nonce"))
+	forged.Header["kid"] = provider.kid
+	signed, err := forged.SignedString(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.verifyIDToken(context.Background(), signed, "nonce"); err == nil {
+		t.Error("verifyIDToken() accepted a token signed with another key")
+	}
+
+	//a rotated key is fetched again, but not more than once per interval.
+	provider.kid = "key-2"
+	client.keysFetchedAt = time.Now().Add(-oidcKeyRefreshInterval)
+	if _, err := client.verifyIDToken(context.Background(), provider.sign(t, jwt.SigningMethodRS256, provider.validClaims("nonce")), "nonce"); err != nil {
+		t.Errorf("verifyIDToken() with a rotated key error = %v", err)
+	}
+
+	provider.kid = "key-3"
+	fetches := provider.keyFetches
+	if _, err := client.verifyIDToken(context.Background(), provider.sign(t, jwt.SigningMethodRS256, provider.validClaims("nonce")), "nonce"); err == nil {
+		t.Error("verifyIDToken() fetched keys again within the interval")
+	}
+	if provider.keyFetches != fetches {
+		t.Errorf("keys fetched %d times within the interval", provider.keyFetches-fetches)
+	}
+}
+
+func TestNewPKCE(t *testing.T) {
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//RFC 7636: the verifier has 43 to 128 characters and the S256 challenge is its base64url SHA-256.
+	if len(verifier) < 43 || len(verifier) > 128 {
+		t.Errorf("NewPKCE() verifier length = %d", len(verifier))
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	if challenge != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Error("NewPKCE() challenge is not the S256 of the verifier")
+	}
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashes are versioned by their encoding: "$argon2id$v=19$m=..,t=..,p=..$salt$hash" or bcrypt "$2a$cost$...".
+// PASSWORD_HASH selects the algorithm of new hashes, hashes with another algorithm or parameters are outdated.
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+func passwordAlgorithm() string {
+	if os.Getenv("PASSWORD_HASH") == AlgorithmBcrypt {
+		return AlgorithmBcrypt
+	}
+	return AlgorithmArgon2id
+}
+
+func bcryptCost() int {
+	cost := utils.IntFromEnv("BCRYPT_COST", bcrypt.DefaultCost)
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return bcrypt.DefaultCost
+	}
+	return cost
+}
+
+// defaults are the OWASP minimum recommendation for argon2id.
+func currentArgon2Params() argon2Params {
+	return argon2Params{
+		memory:  uint32(utils.IntFromEnv("ARGON2_MEMORY", 19*1024)),
+		time:    uint32(utils.IntFromEnv("ARGON2_TIME", 2)),
+		threads: uint8(utils.IntFromEnv("ARGON2_THREADS", 1)),
+	}
+}
+
+// HashPassword hashes password with the configured algorithm, returns empty string on error.
+func HashPassword(password string) string {
+	if passwordAlgorithm() == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost())
+		if err != nil {
+			return ""
+		}
+		return string(hash)
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return ""
+	}
+
+	params := currentArgon2Params()
+	key := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.memory, params.time, params.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// CompareHashAndPassword reports whether password matches the hash of any supported version.
+func CompareHashAndPassword(hashedPassword string, password string) bool {
+	if strings.HasPrefix(hashedPassword, "$argon2id$") {
+		params, salt, key, err := decodeArgon2(hashedPassword)
+		if err != nil {
+			return false
+		}
+		other := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil
+}
+
+// NeedsRehash reports whether the hash was made with another algorithm or parameters than configured now.
+func NeedsRehash(hashedPassword string) bool {
+	if passwordAlgorithm() == AlgorithmBcrypt {
+		cost, err := bcrypt.Cost([]byte(hashedPassword))
+		return err != nil || cost != bcryptCost()
+	}
+
+	params, _, _, err := decodeArgon2(hashedPassword)
+	return err != nil || params != currentArgon2Params()
+}
+
+func decodeArgon2(hashedPassword string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	var version int
+
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("not an argon2id hash")
+	}
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version")
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	return params, salt, key, nil
+}
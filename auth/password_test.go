@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordHashVersions(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		prefix string
+	}{
+		{"argon2id by default", nil, "$argon2id$v=19$m=19456,t=2,p=1$"},
+		{"argon2id with parameters", map[string]string{"ARGON2_MEMORY": "1024", "ARGON2_TIME": "1", "ARGON2_THREADS": "2"}, "$argon2id$v=19$m=1024,t=1,p=2$"},
+		{"bcrypt", map[string]string{"PASSWORD_HASH": "bcrypt", "BCRYPT_COST": "4"}, "$2a$04$"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			hash := HashPassword("correct horse battery")
+			if !strings.HasPrefix(hash, test.prefix) {
+				t.Fatalf("HashPassword() = %q, want prefix %q", hash, test.prefix)
+			}
+			if !CompareHashAndPassword(hash, "correct horse battery") {
+				t.Error("CompareHashAndPassword() with the password = false")
+			}
+			if CompareHashAndPassword(hash, "correct horse battery!") {
+				t.Error("CompareHashAndPassword() with another password = true")
+			}
+			if NeedsRehash(hash) {
+				t.Error("NeedsRehash() of a current hash = true")
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "4")
+	t.Setenv("ARGON2_MEMORY", "1024")
+	t.Setenv("ARGON2_TIME", "1")
+
+	t.Setenv("PASSWORD_HASH", "bcrypt")
+	bcryptHash := HashPassword("password")
+	t.Setenv("PASSWORD_HASH", "")
+	argon2Hash := HashPassword("password")
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		hash string
+		want bool
+	}{
+		{"bcrypt hash when argon2id is configured", nil, bcryptHash, true},
+		{"argon2id hash when bcrypt is configured", map[string]string{"PASSWORD_HASH": "bcrypt"}, argon2Hash, true},
+		{"bcrypt hash with another cost", map[string]string{"PASSWORD_HASH": "bcrypt", "BCRYPT_COST": "5"}, bcryptHash, true},
+		{"argon2id hash with other parameters", map[string]string{"ARGON2_TIME": "2"}, argon2Hash, true},
+		{"argon2id hash with current parameters", nil, argon2Hash, false},
+		{"malformed hash", nil, "$argon2id$v=19$m=1024$broken", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			if got := NeedsRehash(test.hash); got != test.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, test.want)
+			}
+			//old hashes are still accepted so that they can be rehashed on login.
+			if test.hash != "$argon2id$v=19$m=1024$broken" && !CompareHashAndPassword(test.hash, "password") {
+				t.Error("CompareHashAndPassword() of an outdated hash = false")
+			}
+		})
+	}
+}
+
+func TestCompareMalformedHashes(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plain",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$not base64$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+		"$2a$04$short",
+	} {
+		if CompareHashAndPassword(hash, "password") {
+			t.Errorf("CompareHashAndPassword(%q) = true", hash)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		email    string
+		valid    bool
+	}{
+		{"long enough", "correct horse battery", "user@example.com", true},
+		{"too short", "short", "", false},
+		{"too long", strings.Repeat("a", maxPasswordLength+1), "", false},
+		{"common", "password123", "", false},
+		{"email", "User@Example.com", "user@example.com", false},
+		{"email is not checked without email", "user@example.com", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ValidatePassword(test.password, test.email); (err == nil) != test.valid {
+				t.Errorf("ValidatePassword() = %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	_ "embed"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bberkgulay/task-repetition-go/utils"
+)
+
+const maxPasswordLength = 128
+
+//go:embed common-passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]bool {
+	passwords := map[string]bool{}
+	for _, password := range strings.Split(commonPasswordList, "\n") {
+		if password = strings.TrimSpace(password); password != "" {
+			passwords[strings.ToLower(password)] = true
+		}
+	}
+	return passwords
+}()
+
+// ValidatePassword checks password against the password policy: length between PASSWORD_MIN_LENGTH
+// (default 8) and 128 characters, not a common password and not the email of user.
+func ValidatePassword(password string, email string) error {
+	length := utf8.RuneCountInString(password)
+	minLength := utils.IntFromEnv("PASSWORD_MIN_LENGTH", 8)
+
+	if length < minLength {
+		return errors.New("Password must be at least " + strconv.Itoa(minLength) + " characters.")
+	}
+	if length > maxPasswordLength {
+		return errors.New("Password must be at most " + strconv.Itoa(maxPasswordLength) + " characters.")
+	}
+	if commonPasswords[strings.ToLower(password)] {
+		return errors.New("Password is too common. Try another.")
+	}
+	if email != "" && strings.EqualFold(password, email) {
+		return errors.New("Password can not be your email.")
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// authentication methods of a principal.
+const (
+	MethodBasic  = "basic"
+	MethodBearer = "bearer"
+	MethodToken  = "token"
+)
+
+// Principal is the authenticated user of a request. Scopes are only set for personal access tokens,
+// SessionID only for access tokens.
+type Principal struct {
+	UserID    primitive.ObjectID
+	Method    string
+	Roles     []string
+	Scopes    []string
+	SessionID primitive.ObjectID
+}
+
+type principalKey struct{}
+
+// IsToken reports whether the principal is authenticated with a personal access token.
+func (p Principal) IsToken() bool {
+	return p.Method == MethodToken
+}
+
+// HasRole reports whether the principal has the role. Personal access tokens never have a role.
+func (p Principal) HasRole(role string) bool {
+	return !p.IsToken() && HasRole(p.Roles, role)
+}
+
+// HasScope reports whether the principal may use the scope. Only personal access tokens are limited to their scopes.
+func (p Principal) HasScope(scope string) bool {
+	return !p.IsToken() || HasScope(p.Scopes, scope)
+}
+
+// WithPrincipal returns a copy of ctx carrying the principal.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal of the request, false if the request is not authenticated.
+func PrincipalFrom(r *http.Request) (Principal, bool) {
+	principal, ok := r.Context().Value(principalKey{}).(Principal)
+	return principal, ok
+}
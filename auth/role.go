@@ -0,0 +1,28 @@
+package auth
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Roles lists every role a user can have.
+var Roles = []string{RoleUser, RoleAdmin}
+
+// HasRole reports whether the role is in roles.
+func HasRole(roles []string, role string) bool {
+	return contains(roles, role)
+}
+
+// IsRole reports whether role is a known role.
+func IsRole(role string) bool {
+	return contains(Roles, role)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
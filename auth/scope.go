@@ -0,0 +1,33 @@
+package auth
+
+// APITokenPrefix marks personal access tokens so they can be told apart from access tokens.
+const APITokenPrefix = "trg_"
+
+const (
+	ScopeTasksRead            = "tasks:read"
+	ScopeTasksWrite           = "tasks:write"
+	ScopeNotesRead            = "notes:read"
+	ScopeNotesWrite           = "notes:write"
+	ScopeRepetitionTypesRead  = "repetitiontypes:read"
+	ScopeRepetitionTypesWrite = "repetitiontypes:write"
+)
+
+// Scopes lists every scope a personal access token can be granted.
+var Scopes = []string{
+	ScopeTasksRead,
+	ScopeTasksWrite,
+	ScopeNotesRead,
+	ScopeNotesWrite,
+	ScopeRepetitionTypesRead,
+	ScopeRepetitionTypesWrite,
+}
+
+// IsScope reports whether scope is a known scope.
+func IsScope(scope string) bool {
+	return contains(Scopes, scope)
+}
+
+// HasScope reports whether the scope is in the granted scopes.
+func HasScope(granted []string, scope string) bool {
+	return contains(granted, scope)
+}
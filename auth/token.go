@@ -0,0 +1,103 @@
+// Package auth contains the credential primitives used by the controllers.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// AccessClaims are the claims carried by a signed access token. Subject is the user id,
+// SessionID is the id of the login session it is issued for.
+type AccessClaims struct {
+	Roles     []string `json:"roles,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// AccessTokenTTL is read from ACCESS_TOKEN_TTL (e.g. "15m").
+func AccessTokenTTL() time.Duration {
+	return utils.DurationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// RefreshTokenTTL is read from REFRESH_TOKEN_TTL (e.g. "720h").
+func RefreshTokenTTL() time.Duration {
+	return utils.DurationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+// NewAccessToken signs a short-lived access token for the user.
+func NewAccessToken(userId string, roles []string, sessionId string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(AccessTokenTTL())
+
+	claims := AccessClaims{
+		Roles:     roles,
+		SessionID: sessionId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userId,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// ParseAccessToken validates signature and expiry of an access token and returns its claims.
+func ParseAccessToken(token string) (*AccessClaims, error) {
+	var claims AccessClaims
+
+	parsed, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		return secret(), nil
+	})
+	if err != nil || !parsed.Valid {
+		return nil, errors.New("invalid access token")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("access token has no subject")
+	}
+
+	return &claims, nil
+}
+
+// NewOpaqueToken returns a random token for the client and the hash of it to be stored.
+func NewOpaqueToken() (string, string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(bytes)
+
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the stored form of an opaque token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func secret() []byte {
+	return []byte(os.Getenv("JWT_SECRET"))
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// codes of the previous and next period are accepted for clock drift.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random base32 encoded secret for RFC 6238 TOTP.
+func NewTOTPSecret() (string, error) {
+	bytes := make([]byte, 20)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(bytes), nil
+}
+
+// TOTPURI returns the otpauth URI of the secret which authenticator apps read from a QR code.
+func TOTPURI(secret string, issuer string, account string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer + ":" + account)
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// ValidateTOTP checks code against the secret at time now. Codes of a time step at or before
+// lastStep are rejected so a code can not be used twice. Returns the step of the accepted code.
+func ValidateTOTP(secret string, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// NewRecoveryCodes returns n single-use recovery codes and their hashes to be stored.
+func NewRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, 0, n)
+	hashes := make([]string, 0, n)
+
+	for i := 0; i < n; i++ {
+		bytes := make([]byte, 5)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(bytes))
+		code = code[:4] + "-" + code[4:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, HashToken(code))
+	}
+
+	return codes, hashes, nil
+}
+
+func totpCode(key []byte, step int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
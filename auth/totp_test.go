@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test vectors for SHA1, truncated to 6 digits.
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		if got := totpCode([]byte("12345678901234567890"), test.unix/totpPeriod); got != test.code {
+			t.Errorf("totpCode(T=%d) = %s, want %s", test.unix, got, test.code)
+		}
+
+		step, ok := ValidateTOTP(rfc6238Secret, test.code, time.Unix(test.unix, 0), 0)
+		if !ok || step != test.unix/totpPeriod {
+			t.Errorf("ValidateTOTP(T=%d) = %d, %v, want %d, true", test.unix, step, ok, test.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+	code := totpCode([]byte("12345678901234567890"), step)
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		now      time.Time
+		lastStep int64
+		want     bool
+	}{
+		{"current code", rfc6238Secret, code, now, 0, true},
+		{"lowercase secret with spaces", " " + strings.ToLower(rfc6238Secret) + " ", code, now, 0, true},
+		{"previous period for clock drift", rfc6238Secret, code, now.Add(totpPeriod * time.Second), 0, true},
+		{"next period for clock drift", rfc6238Secret, code, now.Add(-totpPeriod * time.Second), 0, true},
+		{"two periods late", rfc6238Secret, code, now.Add(2 * totpPeriod * time.Second), 0, false},
+		{"replayed code", rfc6238Secret, code, now, step, false},
+		{"code of an earlier step than the last one", rfc6238Secret, code, now, step + 1, false},
+		{"wrong code", rfc6238Secret, "000000", now, 0, false},
+		{"short code", rfc6238Secret, code[:5], now, 0, false},
+		{"invalid secret", "not base32!", code, now, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(test.secret, test.code, test.now, test.lastStep); ok != test.want {
+				t.Errorf("ValidateTOTP() = %v, want %v", ok, test.want)
+			}
+		})
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 || len(hashes) != 10 {
+		t.Fatalf("NewRecoveryCodes(10) returned %d codes and %d hashes", len(codes), len(hashes))
+	}
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if len(code) != 9 || code[4] != '-' || code != strings.ToLower(code) {
+			t.Errorf("code %q is not formatted as xxxx-xxxx", code)
+		}
+		if hashes[i] != HashToken(code) {
+			t.Errorf("hash of code %d does not match", i)
+		}
+		if seen[code] {
+			t.Errorf("code %q is repeated", code)
+		}
+		seen[code] = true
+	}
+}
@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// collections holding data of user in the "user" field, they are cleaned when the account is purged. The audit log
+// is not among them: it is the record of what happened to the account, so it is kept until AUDIT_RETENTION like the
+// logs of other users. Invites keep their use count but not the purged user.
+var userDataCollections = []string{
+	"tasks",
+	"notes",
+	"reviews",
+	"ladderproposals",
+	"repetitiontypes",
+	"sessions",
+	"refreshtokens",
+	"apitokens",
+	"passwordresets",
+	"emailverifications",
+	"exports",
+}
+
+// @description Deleted accounts can be restored during the grace period (ACCOUNT_DELETION_GRACE), then they are purged.
+func accountDeletionGrace() time.Duration {
+	return utils.DurationFromEnv("ACCOUNT_DELETION_GRACE", 7*24*time.Hour)
+}
+
+// @route       DELETE /api/v1/me
+// @access      Private
+// @description Deletes account of user after password confirmation. Account is purged with all data after the grace period.
+func (c Controller) DeleteAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user models.User
+		var error models.Error
+		var request struct {
+			Password string
+		}
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.Password == "" {
+			error.Message = "Enter missing fields. (Password)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "_id", Value: userId}, {Key: "deletedat", Value: bson.M{"$exists": false}}}
+		if err := c.DB.Collection("users").FindOne(context.TODO(), filter).Decode(&user); err != nil {
+			error.Message = "Server Error."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if !c.confirmPassword(user, request.Password, r) {
+			error.Message = "Password is incorrect."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		now := time.Now()
+		_, err = c.DB.Collection("users").UpdateOne(
+			context.TODO(),
+			filter,
+			bson.D{{Key: "$set", Value: bson.D{{Key: "deletedat", Value: now}}}},
+		)
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.Credentials.InvalidateUser(userId.Hex())
+
+		if _, err := c.revokeSessions(bson.D{{Key: "user", Value: userId}}); err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		_, err = c.DB.Collection("apitokens").UpdateMany(
+			context.TODO(),
+			bson.D{{Key: "user", Value: userId}, {Key: "revokedat", Value: bson.M{"$exists": false}}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "revokedat", Value: now}}}},
+		)
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.audit(r, AuditAccountDeleted, userId, nil)
+
+		utils.SendSuccess(w, "Account will be deleted at "+now.Add(accountDeletionGrace()).Format(time.RFC3339)+". Until then it can be restored.")
+	}
+}
+
+// @route       POST /api/v1/auth/restore
+// @access      Public
+// @description Restores a deleted account during the grace period with email and password.
+func (c Controller) RestoreAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request models.User
+		var user models.User
+		var error models.Error
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.Email == "" || request.Password == "" {
+			error.Message = "Enter missing fields."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		throttle := c.checkLoginThrottle(request.Email, r)
+		if throttle.Status != 0 {
+			sendLoginThrottled(w, throttle)
+			return
+		}
+
+		filter := bson.D{
+			{Key: "email", Value: normalizeEmail(request.Email)},
+			{Key: "deletedat", Value: bson.M{"$gt": time.Now().Add(-accountDeletionGrace())}},
+		}
+		err := c.DB.Collection("users").FindOne(context.TODO(), filter).Decode(&user)
+		if err != nil || !c.confirmPassword(user, request.Password, r) {
+			c.recordLoginFailure(request.Email, r)
+			error.Message = "Incorrect Email/Password or no deleted account to restore"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
+
+		_, err = c.DB.Collection("users").UpdateOne(
+			context.TODO(),
+			bson.D{{Key: "_id", Value: user.ID}},
+			bson.D{{Key: "$unset", Value: bson.D{{Key: "deletedat", Value: ""}}}},
+		)
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.audit(r, AuditAccountRestored, user.ID, nil)
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
+
+// @description Purges accounts deleted before the grace period periodically until the context is done.
+func (c Controller) RunAccountPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.purgeDeletedAccounts(); err != nil {
+			log.Println("Error while purging deleted accounts:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// @description Removes accounts whose grace period is over with all of their data.
+func (c Controller) purgeDeletedAccounts() error {
+	var users []models.User
+
+	filter := bson.D{{Key: "deletedat", Value: bson.M{"$lte": time.Now().Add(-accountDeletionGrace())}}}
+
+	cursor, err := c.DB.Collection("users").Find(context.TODO(), filter)
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(context.TODO(), &users); err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		var exports []models.Export
+
+		cursor, err := c.DB.Collection("exports").Find(context.TODO(), bson.D{{Key: "user", Value: user.ID}})
+		if err != nil {
+			return err
+		}
+		if err = cursor.All(context.TODO(), &exports); err != nil {
+			return err
+		}
+		for _, export := range exports {
+			if export.File != "" {
+				os.Remove(export.File)
+			}
+		}
+
+		for _, collection := range userDataCollections {
+			if _, err := c.DB.Collection(collection).DeleteMany(context.TODO(), bson.D{{Key: "user", Value: user.ID}}); err != nil {
+				return err
+			}
+		}
+
+		_, err = c.DB.Collection("invites").UpdateMany(
+			context.TODO(),
+			bson.D{{Key: "usedby", Value: user.ID}},
+			bson.D{{Key: "$pull", Value: bson.D{{Key: "usedby", Value: user.ID}}}},
+		)
+		if err != nil {
+			return err
+		}
+
+		c.resetLoginFailures(user.Email)
+
+		if _, err := c.DB.Collection("users").DeleteOne(context.TODO(), bson.D{{Key: "_id", Value: user.ID}}); err != nil {
+			return err
+		}
+
+		c.audit(nil, AuditAccountPurged, user.ID, nil)
+	}
+
+	return nil
+}
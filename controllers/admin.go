@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/auth"
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// @description Gives admin role to the users whose email is listed in ADMIN_EMAILS (comma separated).
+func (c Controller) BootstrapAdmins() error {
+	emails := bson.A{}
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = normalizeEmail(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+
+	if len(emails) == 0 {
+		return nil
+	}
+
+	_, err := c.DB.Collection("users").UpdateMany(
+		context.TODO(),
+		bson.D{{Key: "email", Value: bson.M{"$in": emails}}},
+		bson.D{{Key: "$addToSet", Value: bson.D{{Key: "roles", Value: bson.M{"$each": bson.A{auth.RoleUser, auth.RoleAdmin}}}}}},
+	)
+
+	return err
+}
+
+// @route       GET /api/v1/admin/users
+// @access      Admin
+// @description Returns users page by page. Query parameters: limit (default 50), skip.
+func (c Controller) GetUsers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+		var users []models.User
+
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 || limit > 500 {
+			limit = 50
+		}
+		skip, err := strconv.Atoi(r.URL.Query().Get("skip"))
+		if err != nil || skip < 0 {
+			skip = 0
+		}
+
+		queryOptions := options.FindOptions{}
+		queryOptions.SetSort(bson.D{{Key: "_id", Value: 1}})
+		queryOptions.SetLimit(int64(limit))
+		queryOptions.SetSkip(int64(skip))
+
+		cursor, err := c.DB.Collection("users").Find(context.TODO(), bson.D{}, &queryOptions)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if err = cursor.All(context.TODO(), &users); err != nil {
+			error.Message = "Error while parsing data."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		accounts := make([]models.AccountSummary, 0, len(users))
+		for _, user := range users {
+			accounts = append(accounts, models.NewAccountSummary(user))
+		}
+
+		utils.SendSuccess(w, accounts)
+	}
+}
+
+// @route       PUT /api/v1/admin/users/{id}/disable
+// @access      Admin
+// @description Disables account, user can not login and all tokens of user are revoked.
+func (c Controller) DisableUser() http.HandlerFunc {
+	return c.setUserDisabled(true)
+}
+
+// @route       PUT /api/v1/admin/users/{id}/enable
+// @access      Admin
+// @description Enables a disabled account.
+func (c Controller) EnableUser() http.HandlerFunc {
+	return c.setUserDisabled(false)
+}
+
+func (c Controller) setUserDisabled(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+		params := mux.Vars(r)
+
+		id, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			error.Message = "Incorrect ID value."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		if adminId, _ := currentUserID(r); disabled && id == adminId {
+			error.Message = "You can not disable your own account."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		result, err := c.DB.Collection("users").UpdateOne(
+			context.TODO(),
+			bson.D{{Key: "_id", Value: id}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "disabled", Value: disabled}}}},
+		)
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			error.Message = "There is no user with this ID."
+			utils.SendError(w, http.StatusNotFound, error)
+			return
+		}
+
+		if disabled {
+			c.Credentials.InvalidateUser(id.Hex())
+
+			if _, err := c.revokeSessions(bson.D{{Key: "user", Value: id}}); err != nil {
+				error.Message = "Server error"
+				utils.SendError(w, http.StatusInternalServerError, error)
+				return
+			}
+
+			_, err = c.DB.Collection("apitokens").UpdateMany(
+				context.TODO(),
+				bson.D{{Key: "user", Value: id}, {Key: "revokedat", Value: bson.M{"$exists": false}}},
+				bson.D{{Key: "$set", Value: bson.D{{Key: "revokedat", Value: time.Now()}}}},
+			)
+			if err != nil {
+				error.Message = "Server error"
+				utils.SendError(w, http.StatusInternalServerError, error)
+				return
+			}
+		}
+
+		event := AuditUserEnabled
+		if disabled {
+			event = AuditUserDisabled
+		}
+		c.audit(r, event, id, nil)
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
+
+// @route       PUT /api/v1/admin/users/{id}/roles
+// @access      Admin
+// @description Replaces roles of user. Body {"roles": ["user", "admin"]}
+func (c Controller) SetUserRoles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+		var user models.User
+		var request struct {
+			Roles []string
+		}
+		params := mux.Vars(r)
+
+		id, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			error.Message = "Incorrect ID value."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		for _, role := range request.Roles {
+			if !auth.IsRole(role) {
+				error.Message = "Unknown role: " + role + ". (" + strings.Join(auth.Roles, ", ") + ")"
+				utils.SendError(w, http.StatusBadRequest, error)
+				return
+			}
+		}
+
+		if adminId, _ := currentUserID(r); id == adminId && !auth.HasRole(request.Roles, auth.RoleAdmin) {
+			error.Message = "You can not remove admin role from your own account."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		err = c.DB.Collection("users").FindOneAndUpdate(
+			context.TODO(),
+			bson.D{{Key: "_id", Value: id}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "roles", Value: request.Roles}}}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&user)
+		if err != nil {
+			error.Message = "There is no user with this ID."
+			utils.SendError(w, http.StatusNotFound, error)
+			return
+		}
+
+		c.Credentials.InvalidateUser(id.Hex())
+
+		c.audit(r, AuditRolesChanged, id, map[string]string{"roles": strings.Join(request.Roles, ",")})
+
+		utils.SendSuccess(w, models.NewAccountSummary(user))
+	}
+}
+
+// @route       GET /api/v1/admin/stats
+// @access      Admin
+// @description Returns counts of users, tasks, notes and reviews.
+func (c Controller) GetStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+
+		now := time.Now()
+		counts := []struct {
+			name       string
+			collection string
+			filter     bson.D
+		}{
+			{"Users", "users", bson.D{}},
+			{"DisabledUsers", "users", bson.D{{Key: "disabled", Value: true}}},
+			{"DeletedUsers", "users", bson.D{{Key: "deletedat", Value: bson.M{"$exists": true}}}},
+			{"Admins", "users", bson.D{{Key: "roles", Value: auth.RoleAdmin}}},
+			{"Tasks", "tasks", bson.D{}},
+			{"CompletedTasks", "tasks", bson.D{{Key: "completedday", Value: bson.M{"$exists": true}}}},
+			{"DueTasks", "tasks", bson.D{
+				{Key: "completedday", Value: bson.M{"$exists": false}},
+				{Key: "repetitionbeginday", Value: bson.M{"$lte": now}},
+			}},
+			{"Notes", "notes", bson.D{}},
+			{"Reviews", "reviews", bson.D{}},
+			{"ReviewsLast30Days", "reviews", bson.D{{Key: "reviewedat", Value: bson.M{"$gte": now.AddDate(0, 0, -30)}}}},
+		}
+
+		stats := map[string]int64{}
+		for _, count := range counts {
+			value, err := c.DB.Collection(count.collection).CountDocuments(context.TODO(), count.filter)
+			if err != nil {
+				error.Message = "Server Error"
+				utils.SendError(w, http.StatusInternalServerError, error)
+				return
+			}
+			stats[count.name] = value
+		}
+
+		utils.SendSuccess(w, stats)
+	}
+}
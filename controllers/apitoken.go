@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/auth"
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// @route       POST /api/v1/tokens
+// @access      Private
+// @description Creates personal access token with scopes. The token is returned only once.
+func (c Controller) CreateAPIToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var apiToken models.APIToken
+		var error models.Error
+
+		json.NewDecoder(r.Body).Decode(&apiToken)
+
+		if apiToken.Name == "" || len(apiToken.Scopes) == 0 {
+			error.Message = "Enter missing fields. (Name, Scopes)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		for _, scope := range apiToken.Scopes {
+			if !auth.IsScope(scope) {
+				error.Message = "Unknown scope: " + scope + ". (" + strings.Join(auth.Scopes, ", ") + ")"
+				utils.SendError(w, http.StatusBadRequest, error)
+				return
+			}
+		}
+
+		if !apiToken.ExpiresAt.IsZero() && apiToken.ExpiresAt.Before(time.Now()) {
+			error.Message = "Expiry must be in the future."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		userId, hexError := currentUserID(r)
+		if hexError != nil {
+			error.Message = "Error occurred getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		random, _, err := auth.NewOpaqueToken()
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+		token := auth.APITokenPrefix + random
+
+		apiToken.ID = primitive.NilObjectID
+		apiToken.User = userId
+		apiToken.Prefix = token[:len(auth.APITokenPrefix)+4]
+		apiToken.TokenHash = auth.HashToken(token)
+		apiToken.CreatedAt = time.Now()
+		apiToken.LastUsedAt = time.Time{}
+		apiToken.RevokedAt = time.Time{}
+
+		insertResult, err := c.DB.Collection("apitokens").InsertOne(context.TODO(), apiToken)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		apiToken.ID = insertResult.InsertedID.(primitive.ObjectID)
+		apiToken.Token = token
+
+		c.audit(r, AuditTokenCreated, userId, map[string]string{"token": apiToken.ID.Hex(), "scopes": strings.Join(apiToken.Scopes, ",")})
+
+		utils.SendSuccess(w, apiToken)
+	}
+}
+
+// @route       GET /api/v1/tokens
+// @access      Private
+// @description Returns personal access tokens of user.
+func (c Controller) GetAPITokens() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+		var apiTokens []models.APIToken
+
+		userId, hexError := currentUserID(r)
+		if hexError != nil {
+			error.Message = "Error occurred about user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		queryOptions := options.FindOptions{}
+		queryOptions.SetSort(bson.D{{Key: "createdat", Value: -1}})
+
+		cursor, err := c.DB.Collection("apitokens").Find(context.TODO(), bson.D{{Key: "user", Value: userId}}, &queryOptions)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if err = cursor.All(context.TODO(), &apiTokens); err != nil {
+			error.Message = "Error while parsing data."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		utils.SendSuccess(w, apiTokens)
+	}
+}
+
+// @route       DELETE /api/v1/tokens/{id}
+// @access      Private
+// @description Revokes personal access token by id with owner control.
+func (c Controller) RevokeAPIToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+		params := mux.Vars(r)
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		id, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			error.Message = "Incorrect ID value."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "_id", Value: id}, {Key: "user", Value: userId}, {Key: "revokedat", Value: bson.M{"$exists": false}}}
+		result, err := c.DB.Collection("apitokens").UpdateOne(
+			context.TODO(),
+			filter,
+			bson.D{{Key: "$set", Value: bson.D{{Key: "revokedat", Value: time.Now()}}}},
+		)
+
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			error.Message = "No token to revoke"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		c.audit(r, AuditTokenRevoked, userId, map[string]string{"token": id.Hex()})
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
+
+// @description Authorisation control of personal access token, returns the owner with the scopes of the token as principal if it is valid.
+func (c Controller) isAuthorisedByAPIToken(token string) (auth.Principal, bool) {
+	var apiToken models.APIToken
+
+	filter := bson.D{{Key: "tokenhash", Value: auth.HashToken(token)}, {Key: "revokedat", Value: bson.M{"$exists": false}}}
+
+	err := c.DB.Collection("apitokens").FindOne(context.TODO(), filter).Decode(&apiToken)
+	if err != nil {
+		return auth.Principal{}, false
+	}
+
+	now := time.Now()
+	if !apiToken.ExpiresAt.IsZero() && now.After(apiToken.ExpiresAt) {
+		return auth.Principal{}, false
+	}
+
+	c.DB.Collection("apitokens").UpdateOne(
+		context.TODO(),
+		bson.D{{Key: "_id", Value: apiToken.ID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "lastusedat", Value: now}}}},
+	)
+
+	return auth.Principal{UserID: apiToken.User, Method: auth.MethodToken, Scopes: apiToken.Scopes}, true
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// events of the audit log.
+const (
+	AuditRegister              = "register"
+	AuditIdentityLinked        = "identity.linked"
+	AuditLoginSucceeded        = "login.succeeded"
+	AuditLoginFailed           = "login.failed"
+	AuditLoginLocked           = "login.locked"
+	AuditLogout                = "logout"
+	AuditRefreshTokenReused    = "refreshtoken.reused"
+	AuditSessionRevoked        = "session.revoked"
+	AuditPasswordChanged       = "password.changed"
+	AuditPasswordResetSent     = "password.resetrequested"
+	AuditPasswordReset         = "password.reset"
+	AuditEmailChangeSent       = "email.changerequested"
+	AuditEmailVerified         = "email.verified"
+	AuditTwoFactorEnabled      = "twofactor.enabled"
+	AuditTwoFactorDisabled     = "twofactor.disabled"
+	AuditTokenCreated          = "token.created"
+	AuditTokenRevoked          = "token.revoked"
+	AuditAccountDeleted        = "account.deleted"
+	AuditAccountRestored       = "account.restored"
+	AuditAccountPurged         = "account.purged"
+	AuditUserDisabled          = "admin.user.disabled"
+	AuditUserEnabled           = "admin.user.enabled"
+	AuditRolesChanged          = "admin.user.roleschanged"
+	AuditLoginUnlocked         = "admin.login.unlocked"
+	AuditInviteCreated         = "admin.invite.created"
+	AuditInviteRevoked         = "admin.invite.revoked"
+	AuditRepetitionTypeAdded   = "admin.repetitiontype.added"
+	AuditRepetitionTypeUpdated = "admin.repetitiontype.updated"
+	AuditRepetitionTypeDeleted = "admin.repetitiontype.deleted"
+)
+
+// @route       GET /api/v1/admin/audit
+// @access      Admin
+// @description Returns audit log newest first. Query parameters: user, event, since, until (RFC 3339), limit (default 50), skip.
+func (c Controller) GetAuditLogs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+
+		query := r.URL.Query()
+		filter := bson.D{}
+
+		if query.Get("user") != "" {
+			userId, err := primitive.ObjectIDFromHex(query.Get("user"))
+			if err != nil {
+				error.Message = "Incorrect user ID value."
+				utils.SendError(w, http.StatusBadRequest, error)
+				return
+			}
+			filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+				bson.D{{Key: "user", Value: userId}},
+				bson.D{{Key: "actor", Value: userId}},
+			}})
+		}
+
+		if query.Get("event") != "" {
+			filter = append(filter, bson.E{Key: "event", Value: query.Get("event")})
+		}
+
+		createdAt := bson.M{}
+		for parameter, operator := range map[string]string{"since": "$gte", "until": "$lt"} {
+			if query.Get(parameter) == "" {
+				continue
+			}
+			value, err := time.Parse(time.RFC3339, query.Get(parameter))
+			if err != nil {
+				error.Message = "Incorrect " + parameter + " value, use RFC 3339."
+				utils.SendError(w, http.StatusBadRequest, error)
+				return
+			}
+			createdAt[operator] = value
+		}
+		if len(createdAt) > 0 {
+			filter = append(filter, bson.E{Key: "createdat", Value: createdAt})
+		}
+
+		c.sendAuditLogs(w, r, filter)
+	}
+}
+
+// @route       GET /api/v1/me/audit
+// @access      Private
+// @description Returns audit log of user's own account newest first. Query parameters: limit (default 50), skip.
+func (c Controller) GetMyAuditLogs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		c.sendAuditLogs(w, r, bson.D{{Key: "user", Value: userId}})
+	}
+}
+
+// @description Sends a page of the audit logs matching the filter.
+func (c Controller) sendAuditLogs(w http.ResponseWriter, r *http.Request, filter bson.D) {
+	var auditLogs []models.AuditLog
+	var error models.Error
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	skip, err := strconv.Atoi(r.URL.Query().Get("skip"))
+	if err != nil || skip < 0 {
+		skip = 0
+	}
+
+	queryOptions := options.FindOptions{}
+	queryOptions.SetSort(bson.D{{Key: "createdat", Value: -1}})
+	queryOptions.SetLimit(int64(limit))
+	queryOptions.SetSkip(int64(skip))
+
+	cursor, err := c.DB.Collection("auditlogs").Find(context.TODO(), filter, &queryOptions)
+	if err != nil {
+		error.Message = "Server Error"
+		utils.SendError(w, http.StatusInternalServerError, error)
+		return
+	}
+
+	if err = cursor.All(context.TODO(), &auditLogs); err != nil {
+		error.Message = "Error while parsing data."
+		utils.SendError(w, http.StatusInternalServerError, error)
+		return
+	}
+
+	utils.SendSuccess(w, auditLogs)
+}
+
+// @description Writes event about user to the audit log with the client of the request, r is nil for background jobs.
+// The authenticated user of the request is recorded as actor when it is not the user. Failures are logged, they never fail the request.
+func (c Controller) audit(r *http.Request, event string, user primitive.ObjectID, details map[string]string) {
+	auditLog := models.AuditLog{
+		Event:     event,
+		User:      user,
+		Details:   details,
+		CreatedAt: time.Now(),
+	}
+
+	//events of background jobs have no request.
+	if r != nil {
+		auditLog.IP = utils.ClientIP(r)
+		auditLog.UserAgent = r.UserAgent()
+
+		if actor, err := currentUserID(r); err == nil && actor != user {
+			auditLog.Actor = actor
+		}
+	}
+
+	if _, err := c.DB.Collection("auditlogs").InsertOne(context.TODO(), auditLog); err != nil {
+		log.Println("Error while writing audit log:", event, err)
+	}
+}
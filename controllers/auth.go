@@ -3,12 +3,17 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/bberkgulay/task-repetition-go/auth"
 	"github.com/bberkgulay/task-repetition-go/models"
 	"github.com/bberkgulay/task-repetition-go/utils"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -16,8 +21,7 @@ import (
 // @access      Public
 func (c Controller) Login() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var user models.User
-		var userOnDB models.User
+		var user models.Credentials
 		var error models.Error
 
 		json.NewDecoder(r.Body).Decode(&user)
@@ -28,42 +32,313 @@ func (c Controller) Login() http.HandlerFunc {
 			return
 		}
 
-		filter := bson.D{{Key: "email", Value: user.Email}}
+		throttle := c.checkLoginThrottle(user.Email, r)
+		if throttle.Status != 0 {
+			sendLoginThrottled(w, throttle)
+			return
+		}
+
+		userOnDB, ok := c.verifyPassword(user.Email, user.Password, r)
+		if !ok {
+			c.recordLoginFailure(user.Email, r)
+			error.Message = "Incorrect Email/Password"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
 
-		err := c.DB.Collection("users").FindOne(context.TODO(), filter).Decode(&userOnDB)
-		if err != nil || !utils.CompareHashAndPassword(userOnDB.Password, user.Password) {
-			error.Message = "Incorrect Email/Passwordasdasd"
+		if userOnDB.TOTPEnabled {
+			if user.Code == "" && user.RecoveryCode == "" {
+				error.Message = "Two-factor code is required."
+				utils.SendError(w, http.StatusUnauthorized, error)
+				return
+			}
+
+			if !c.verifySecondFactor(userOnDB, user) {
+				c.recordLoginFailure(user.Email, r)
+				error.Message = "Incorrect two-factor code."
+				utils.SendError(w, http.StatusUnauthorized, error)
+				return
+			}
+		}
+
+		if throttle.Failures > 0 {
+			c.resetLoginFailures(user.Email)
+		}
+
+		if !userOnDB.DeletedAt.IsZero() {
+			error.Message = "Account is deleted. It can be restored until " + userOnDB.DeletedAt.Add(accountDeletionGrace()).Format(time.RFC3339) + "."
+			utils.SendError(w, http.StatusForbidden, error)
+			return
+		}
+
+		if userOnDB.Disabled {
+			error.Message = "Account is disabled."
+			utils.SendError(w, http.StatusForbidden, error)
+			return
+		}
+
+		sessionId, err := c.startSession(userOnDB, r)
+		if err != nil {
+			error.Message = "Server Error"
 			utils.SendError(w, http.StatusInternalServerError, error)
 			return
 		}
 
-		utils.SendSuccess(w, true)
+		tokens, err := c.issueTokens(userOnDB, sessionId, primitive.NewObjectID())
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.audit(r, AuditLoginSucceeded, userOnDB.ID, map[string]string{"method": "password", "session": sessionId.Hex()})
+
+		utils.SendSuccess(w, tokens)
 	}
 }
 
+// @route       POST /api/v1/auth/refresh
+// @access      Public
+// @description Rotates refresh token and returns a new access token. Reusing a rotated refresh token revokes all of its family.
+func (c Controller) Refresh() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request models.TokenPair
+		var refreshToken models.RefreshToken
+		var error models.Error
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.RefreshToken == "" {
+			error.Message = "Enter missing fields. (RefreshToken)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "tokenhash", Value: auth.HashToken(request.RefreshToken)}}
+		err := c.DB.Collection("refreshtokens").FindOne(context.TODO(), filter).Decode(&refreshToken)
+		if err != nil {
+			error.Message = "Invalid refresh token"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
+
+		if !refreshToken.RevokedAt.IsZero() {
+			//a rotated token is used again, it may be stolen so the whole session is revoked.
+			if !refreshToken.ReplacedBy.IsZero() {
+				c.revokeReusedRefreshToken(r, refreshToken)
+			}
+			error.Message = "Invalid refresh token"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
+
+		if time.Now().After(refreshToken.ExpiresAt) {
+			error.Message = "Refresh token is expired"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
+
+		session, err := c.findActiveSession(refreshToken.Family)
+		if err != nil {
+			error.Message = "Session is revoked or expired"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
+
+		//roles are read again so that changes are in the new access token.
+		var user models.User
+		userFilter := bson.D{{Key: "_id", Value: refreshToken.User}, {Key: "disabled", Value: bson.M{"$ne": true}}, {Key: "deletedat", Value: bson.M{"$exists": false}}}
+		if err := c.DB.Collection("users").FindOne(context.TODO(), userFilter).Decode(&user); err != nil {
+			error.Message = "Invalid refresh token"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
+
+		//the token is claimed before new tokens are issued, so that only one of concurrent refreshes with it succeeds.
+		//the others are reuse of a rotated token. It is marked replaced in the same write, so that it is detected as
+		//reused as soon as it is claimed.
+		newRefreshTokenId := primitive.NewObjectID()
+		claimFilter := bson.D{{Key: "_id", Value: refreshToken.ID}, {Key: "revokedat", Value: bson.M{"$exists": false}}}
+		claimUpdate := bson.D{{Key: "$set", Value: bson.D{{Key: "revokedat", Value: time.Now()}, {Key: "replacedby", Value: newRefreshTokenId}}}}
+		err = c.DB.Collection("refreshtokens").FindOneAndUpdate(context.TODO(), claimFilter, claimUpdate).Err()
+		if err == mongo.ErrNoDocuments {
+			c.revokeReusedRefreshToken(r, refreshToken)
+			error.Message = "Invalid refresh token"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		tokens, err := c.issueTokens(user, refreshToken.Family, newRefreshTokenId)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.touchSession(session, r, true)
+
+		utils.SendSuccess(w, tokens)
+	}
+}
+
+// @route       POST /api/v1/auth/logout
+// @access      Public
+// @description Revokes session of the refresh token with all of its tokens.
+func (c Controller) Logout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request models.TokenPair
+		var refreshToken models.RefreshToken
+		var error models.Error
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.RefreshToken == "" {
+			error.Message = "Enter missing fields. (RefreshToken)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "tokenhash", Value: auth.HashToken(request.RefreshToken)}}
+		err := c.DB.Collection("refreshtokens").FindOne(context.TODO(), filter).Decode(&refreshToken)
+		if err != nil {
+			error.Message = "Invalid refresh token"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
+
+		if _, err := c.revokeSessions(bson.D{{Key: "_id", Value: refreshToken.Family}}); err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.audit(r, AuditLogout, refreshToken.User, map[string]string{"session": refreshToken.Family.Hex()})
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
+
+// @description Creates access token and refresh token of user for the session. The refresh token is stored with
+// refreshTokenId, so that the token it replaces can refer to it before it is created.
+func (c Controller) issueTokens(user models.User, sessionId primitive.ObjectID, refreshTokenId primitive.ObjectID) (*models.TokenPair, error) {
+	refreshToken, refreshTokenHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	_, err = c.DB.Collection("refreshtokens").InsertOne(context.TODO(), models.RefreshToken{
+		ID:        refreshTokenId,
+		User:      user.ID,
+		Family:    sessionId,
+		TokenHash: refreshTokenHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(auth.RefreshTokenTTL()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, expiresAt, err := auth.NewAccessToken(user.ID.Hex(), user.Roles, sessionId.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(expiresAt.Sub(now).Seconds()),
+	}, nil
+}
+
+// @description Revokes the session of a refresh token which is used again after it is rotated, it may be stolen.
+func (c Controller) revokeReusedRefreshToken(r *http.Request, refreshToken models.RefreshToken) {
+	c.revokeSessions(bson.D{{Key: "_id", Value: refreshToken.Family}})
+	c.audit(r, AuditRefreshTokenReused, refreshToken.User, map[string]string{"session": refreshToken.Family.Hex()})
+}
+
+// @description Revokes refresh tokens matching the filter which are not revoked yet.
+func (c Controller) revokeRefreshTokens(filter bson.D) error {
+	filter = append(filter, bson.E{Key: "revokedat", Value: bson.M{"$exists": false}})
+
+	_, err := c.DB.Collection("refreshtokens").UpdateMany(
+		context.TODO(),
+		filter,
+		bson.D{{Key: "$set", Value: bson.D{{Key: "revokedat", Value: time.Now()}}}},
+	)
+
+	return err
+}
+
 // @route       POST /api/v1/auth/register
 // @access      Public
+// @description Registers user if registration is open. In invite mode (REGISTRATION_MODE) an InviteCode is required.
 func (c Controller) Register() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var user models.User
+		//only these fields are taken from the client, all others of the user are set here.
+		var request struct {
+			Name       string
+			Surname    string
+			Email      string
+			Password   string
+			InviteCode string
+		}
 		var error models.Error
 
-		json.NewDecoder(r.Body).Decode(&user)
+		mode := registrationMode()
+		if mode == RegistrationClosed {
+			error.Message = "Registration is closed."
+			utils.SendError(w, http.StatusForbidden, error)
+			return
+		}
+
+		json.NewDecoder(r.Body).Decode(&request)
+		request.Email = normalizeEmail(request.Email)
+
+		if mode == RegistrationInvite && request.InviteCode == "" {
+			error.Message = "An invite code is required to register."
+			utils.SendError(w, http.StatusForbidden, error)
+			return
+		}
 
-		if user.Email == "" || user.Name == "" || user.Surname == "" || user.Password == "" {
+		if request.Email == "" || request.Name == "" || request.Surname == "" || request.Password == "" {
 			error.Message = "Enter missing fields."
 			utils.SendError(w, http.StatusBadRequest, error)
 			return
 		}
 
-		hashedPassword := utils.HashPassword(user.Password)
+		if !isValidEmail(request.Email) {
+			error.Message = "Enter a valid email address."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		if err := auth.ValidatePassword(request.Password, request.Email); err != nil {
+			error.Message = err.Error()
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		hashedPassword := auth.HashPassword(request.Password)
 		if hashedPassword == "" {
 			error.Message = "Error while hashing password."
 			utils.SendError(w, http.StatusBadRequest, error)
 			return
 		}
 
-		user.Password = hashedPassword
+		user := models.User{
+			Name:     request.Name,
+			Surname:  request.Surname,
+			Email:    request.Email,
+			Password: hashedPassword,
+			Roles:    []string{auth.RoleUser},
+		}
 
 		filter := bson.D{{Key: "email", Value: user.Email}}
 		existedUser, findError := c.DB.Collection("users").CountDocuments(context.TODO(), filter)
@@ -79,61 +354,231 @@ func (c Controller) Register() http.HandlerFunc {
 			return
 		}
 
+		user.ID = primitive.NewObjectID()
+
+		var inviteId primitive.ObjectID
+		if mode == RegistrationInvite {
+			var ok bool
+			if inviteId, ok = c.useInvite(request.InviteCode, user.ID, user.Email); !ok {
+				error.Message = "Invalid, expired or used invite code"
+				utils.SendError(w, http.StatusForbidden, error)
+				return
+			}
+		}
+
 		insertResult, err := c.DB.Collection("users").InsertOne(context.TODO(), user)
 
 		if err != nil {
+			if !inviteId.IsZero() {
+				c.releaseInvite(inviteId, user.ID)
+			}
+			//the email may have been registered since it was checked.
+			if mongo.IsDuplicateKeyError(err) {
+				error.Message = "That username is taken. Try another."
+				utils.SendError(w, http.StatusBadRequest, error)
+				return
+			}
 			error.Message = "Server Error"
 			utils.SendError(w, http.StatusInternalServerError, error)
 			return
 		}
 
+		//user is registered even if the email can not be sent, verification can be requested again.
+		if err := c.sendEmailVerification(user, user.Email); err != nil {
+			log.Println("Error while sending verification email:", err)
+		}
+
+		details := map[string]string{"method": "password"}
+		if !inviteId.IsZero() {
+			details["invite"] = inviteId.Hex()
+		}
+		c.audit(r, AuditRegister, user.ID, details)
+
 		utils.SendSuccess(w, insertResult.InsertedID)
 	}
 }
 
-// @description Middleware for authentication of endpoints.
+// @description Middleware for authentication of endpoints. Protection of the matched route is declared with RegisterRoutes.
+// The authenticated user is added to the request context, handlers read it with auth.PrincipalFrom.
 func (c Controller) LoginControl(h http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		if strings.Contains(r.URL.Path, "/auth/") {
+		route := c.matchedRoute(r)
+
+		if route.Access == Public {
 			h.ServeHTTP(w, r)
-		} else {
-			username, password, ok := r.BasicAuth()
-			var error models.Error
+			return
+		}
+
+		principal, ok := c.authenticate(w, r)
+		if !ok || !authoriseRoute(route, principal, w) {
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// @description Authenticates request with access token, personal access token or basic auth. Sends error response if it fails.
+func (c Controller) authenticate(w http.ResponseWriter, r *http.Request) (auth.Principal, bool) {
+	var error models.Error
+
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		token := strings.TrimPrefix(authorization, "Bearer ")
 
+		if strings.HasPrefix(token, auth.APITokenPrefix) {
+			principal, ok := c.isAuthorisedByAPIToken(token)
 			if !ok {
-				error.Message = "No basic auth present"
+				error.Message = "Invalid, expired or revoked token"
 				utils.SendError(w, http.StatusUnauthorized, error)
-				return
 			}
 
-			if !isAuthorised(username, password, c.DB, r) {
-				error.Message = "Invalid username or password"
-				utils.SendError(w, http.StatusUnauthorized, error)
-				return
-			}
+			return principal, ok
+		}
 
-			h.ServeHTTP(w, r)
+		claims, err := auth.ParseAccessToken(token)
+		if err != nil {
+			error.Message = "Invalid or expired token"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return auth.Principal{}, false
 		}
 
-	})
-}
+		userId, err := primitive.ObjectIDFromHex(claims.Subject)
+		if err != nil {
+			error.Message = "Invalid or expired token"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return auth.Principal{}, false
+		}
+
+		//access tokens stop working as soon as their session is revoked.
+		sessionId, err := primitive.ObjectIDFromHex(claims.SessionID)
+		if err != nil {
+			error.Message = "Invalid or expired token"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return auth.Principal{}, false
+		}
+
+		session, err := c.findActiveSession(sessionId)
+		if err != nil || session.User != userId {
+			error.Message = "Session is revoked or expired"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return auth.Principal{}, false
+		}
+		c.touchSession(session, r, false)
+
+		return auth.Principal{UserID: userId, Method: auth.MethodBearer, Roles: claims.Roles, SessionID: sessionId}, true
+	}
+
+	username, password, ok := r.BasicAuth()
+
+	if !ok {
+		error.Message = "No basic auth present"
+		utils.SendError(w, http.StatusUnauthorized, error)
+		return auth.Principal{}, false
+	}
+
+	//locked accounts and blocked addresses are refused before the cache is consulted.
+	throttle := c.checkLoginThrottle(username, r)
+	if throttle.Status != 0 {
+		sendLoginThrottled(w, throttle)
+		return auth.Principal{}, false
+	}
+
+	//recently verified credentials skip the user lookup and password comparison.
+	if credential, ok := c.Credentials.Get(username, password); ok {
+		if userId, err := primitive.ObjectIDFromHex(credential.UserID); err == nil {
+			if throttle.Failures > 0 {
+				c.resetLoginFailures(username)
+			}
+			return auth.Principal{UserID: userId, Method: auth.MethodBasic, Roles: credential.Roles}, true
+		}
+	}
 
-//@description Authorisation control of user if authorised, user id will be added to header.
-func isAuthorised(username string, password string, db *mongo.Database, r *http.Request) bool {
-	var user models.User
+	principal, ok := c.isAuthorised(username, password, r)
+	if !ok {
+		c.recordLoginFailure(username, r)
+		error.Message = "Invalid username or password"
+		utils.SendError(w, http.StatusUnauthorized, error)
+		return auth.Principal{}, false
+	}
 
-	filter := bson.D{{Key: "email", Value: username}}
+	if throttle.Failures > 0 {
+		c.resetLoginFailures(username)
+	}
 
-	err := db.Collection("users").FindOne(context.TODO(), filter).Decode(&user)
+	return principal, true
+}
 
-	if err != nil || !utils.CompareHashAndPassword(user.Password, password) {
+// @description Checks role and scope required by the route. Personal access tokens must have the scope of the route
+// and never have a role, other authentication methods have all scopes. Routes without a scope, like account
+// management, can not be used with a token.
+func authoriseRoute(route Route, principal auth.Principal, w http.ResponseWriter) bool {
+	var error models.Error
+
+	if route.Scope == "" && principal.IsToken() {
+		error.Message = "This endpoint can not be used with a personal access token."
+		utils.SendError(w, http.StatusForbidden, error)
 		return false
 	}
 
-	r.Header.Set("userID", user.ID.Hex())
+	if route.Access == Admin && !principal.HasRole(auth.RoleAdmin) {
+		error.Message = "This endpoint requires the role: " + auth.RoleAdmin
+		utils.SendError(w, http.StatusForbidden, error)
+		return false
+	}
+
+	if route.Scope != "" && !principal.HasScope(route.Scope) {
+		error.Message = "Token does not have the required scope: " + route.Scope
+		utils.SendError(w, http.StatusForbidden, error)
+		return false
+	}
 
 	return true
+}
+
+//@description Authorisation control of user with basic auth credentials, returns the user as principal if authorised.
+func (c Controller) isAuthorised(username string, password string, r *http.Request) (auth.Principal, bool) {
+	user, ok := c.verifyPassword(username, password, r)
+
+	//accounts with two-factor authentication can not use basic auth, they login with a code.
+	if !ok || user.Disabled || !user.DeletedAt.IsZero() || user.TOTPEnabled {
+		return auth.Principal{}, false
+	}
+
+	c.Credentials.Put(username, password, user.ID.Hex(), user.Roles)
 
+	return auth.Principal{UserID: user.ID, Method: auth.MethodBasic, Roles: user.Roles}, true
+
+}
+
+// @description Returns id of the authenticated user of the request.
+func currentUserID(r *http.Request) (primitive.ObjectID, error) {
+	principal, ok := auth.PrincipalFrom(r)
+	if !ok {
+		return primitive.NilObjectID, errors.New("request is not authenticated")
+	}
+	return principal.UserID, nil
+}
+
+// @description Replaces an outdated password hash of user with a hash of the configured algorithm after a successful login.
+// The update only applies if the hash is not changed meanwhile.
+func (c Controller) rehashPassword(user models.User, password string) {
+	if !auth.NeedsRehash(user.Password) {
+		return
+	}
+
+	hashedPassword := auth.HashPassword(password)
+	if hashedPassword == "" {
+		return
+	}
+
+	_, err := c.DB.Collection("users").UpdateOne(
+		context.TODO(),
+		bson.D{{Key: "_id", Value: user.ID}, {Key: "password", Value: user.Password}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: hashedPassword}}}},
+	)
+	if err != nil {
+		log.Println("Error while rehashing password:", err)
+	}
 }
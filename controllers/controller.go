@@ -1,7 +1,20 @@
 package controllers
 
-import "go.mongodb.org/mongo-driver/mongo"
+import (
+	"github.com/bberkgulay/task-repetition-go/auth"
+	"github.com/bberkgulay/task-repetition-go/mailer"
+	"go.mongodb.org/mongo-driver/mongo"
+)
 
 type Controller struct {
-	DB *mongo.Database
+	DB     *mongo.Database
+	Mailer mailer.Mailer
+	// Routes are registered with RegisterRoutes, keyed by "METHOD path".
+	Routes map[string]Route
+	// Credentials caches verified basic auth credentials.
+	Credentials *auth.CredentialCache
+	// OIDC is the single sign-on provider, nil if it is not configured.
+	OIDC *auth.OIDCProvider
+	// Directory checks passwords of users which do not match the stored password, nil if it is not configured.
+	Directory auth.Directory
 }
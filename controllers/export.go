@@ -0,0 +1,407 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// exportData is the content of export.json in the archive.
+type exportData struct {
+	ExportedAt      time.Time
+	Profile         models.Profile
+	Tasks           []models.Task
+	Notes           []models.Note
+	RepetitionTypes []models.RepetitionType
+	Reviews         []models.Review
+}
+
+// @route       GET /api/v1/me/export
+// @access      Private
+// @description Returns zip archive (JSON and CSV) of all data of user. Large accounts are exported in background,
+// then 202 is returned with the export job which can be followed on /me/exports/{id}. While a job of user is pending
+// or running, 409 is returned with its location.
+func (c Controller) ExportAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		size := int64(0)
+		for _, collection := range []string{"tasks", "notes", "reviews"} {
+			count, err := c.DB.Collection(collection).CountDocuments(context.TODO(), bson.D{{Key: "user", Value: userId}})
+			if err != nil {
+				error.Message = "Server Error"
+				utils.SendError(w, http.StatusInternalServerError, error)
+				return
+			}
+			size += count
+		}
+
+		if size <= int64(utils.IntFromEnv("EXPORT_SYNC_LIMIT", 1000)) {
+			var archive bytes.Buffer
+			if err := c.writeExportArchive(&archive, userId); err != nil {
+				error.Message = "Error while exporting data."
+				utils.SendError(w, http.StatusInternalServerError, error)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", `attachment; filename="export.zip"`)
+			w.Write(archive.Bytes())
+			return
+		}
+
+		//one background export runs at a time per user.
+		var running models.Export
+		filter := bson.D{{Key: "user", Value: userId}, {Key: "status", Value: bson.M{"$in": bson.A{models.ExportPending, models.ExportRunning}}}}
+		err = c.DB.Collection("exports").FindOne(context.TODO(), filter).Decode(&running)
+		if err == nil {
+			w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/export")+"/exports/"+running.ID.Hex())
+			error.Message = "An export is already in progress."
+			utils.SendError(w, http.StatusConflict, error)
+			return
+		}
+		if err != mongo.ErrNoDocuments {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		export := models.Export{
+			ID:        primitive.NewObjectID(),
+			User:      userId,
+			Status:    models.ExportPending,
+			CreatedAt: time.Now(),
+		}
+		if _, err := c.DB.Collection("exports").InsertOne(context.TODO(), export); err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		go c.runExport(export)
+
+		w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/export")+"/exports/"+export.ID.Hex())
+		w.WriteHeader(http.StatusAccepted)
+		utils.SendSuccess(w, export)
+	}
+}
+
+// @route       GET /api/v1/me/exports/{id}
+// @access      Private
+// @description Returns status of export job, download url is added when it is done.
+func (c Controller) GetExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		export, ok := c.findExport(w, r)
+		if !ok {
+			return
+		}
+
+		if export.Status == models.ExportDone {
+			export.DownloadURL = strings.TrimSuffix(r.URL.Path, "/") + "/download"
+		}
+
+		utils.SendSuccess(w, export)
+	}
+}
+
+// @route       GET /api/v1/me/exports/{id}/download
+// @access      Private
+// @description Downloads archive of a finished export job.
+func (c Controller) DownloadExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+
+		export, ok := c.findExport(w, r)
+		if !ok {
+			return
+		}
+
+		if export.Status == models.ExportExpired {
+			error.Message = "Export is expired, request a new one."
+			utils.SendError(w, http.StatusGone, error)
+			return
+		}
+
+		if export.Status != models.ExportDone {
+			error.Message = "Export is not ready."
+			utils.SendError(w, http.StatusConflict, error)
+			return
+		}
+
+		file, err := os.Open(export.File)
+		if err != nil {
+			error.Message = "Export file is not found."
+			utils.SendError(w, http.StatusNotFound, error)
+			return
+		}
+		defer file.Close()
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="export.zip"`)
+		io.Copy(w, file)
+	}
+}
+
+// @description Finds export job of user from the id in path, sends error response if it is not found.
+func (c Controller) findExport(w http.ResponseWriter, r *http.Request) (*models.Export, bool) {
+	var export models.Export
+	var error models.Error
+
+	params := mux.Vars(r)
+
+	id, err := primitive.ObjectIDFromHex(params["id"])
+	if err != nil {
+		error.Message = "Incorrect ID value."
+		utils.SendError(w, http.StatusBadRequest, error)
+		return nil, false
+	}
+
+	userId, err := currentUserID(r)
+	if err != nil {
+		error.Message = "Error while getting user."
+		utils.SendError(w, http.StatusBadRequest, error)
+		return nil, false
+	}
+
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "user", Value: userId}}
+	if err := c.DB.Collection("exports").FindOne(context.TODO(), filter).Decode(&export); err != nil {
+		error.Message = "There is no export with this ID of user."
+		utils.SendError(w, http.StatusNotFound, error)
+		return nil, false
+	}
+
+	return &export, true
+}
+
+// @description Writes archive of export job to EXPORT_DIR and records the result on the job.
+func (c Controller) runExport(export models.Export) {
+	filter := bson.D{{Key: "_id", Value: export.ID}}
+	c.DB.Collection("exports").UpdateOne(context.TODO(), filter, bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: models.ExportRunning}}}})
+
+	file, err := c.writeExportFile(export)
+
+	update := bson.D{{Key: "status", Value: models.ExportDone}, {Key: "file", Value: file}, {Key: "completedat", Value: time.Now()}}
+	if err != nil {
+		log.Println("Error while exporting data:", err)
+		update = bson.D{{Key: "status", Value: models.ExportFailed}, {Key: "error", Value: "Error while exporting data."}, {Key: "completedat", Value: time.Now()}}
+	}
+
+	c.DB.Collection("exports").UpdateOne(context.TODO(), filter, bson.D{{Key: "$set", Value: update}})
+}
+
+func (c Controller) writeExportFile(export models.Export) (string, error) {
+	directory := exportDirectory()
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(directory, export.ID.Hex()+".zip")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := c.writeExportArchive(file, export.User); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// @description Archives of exports are deleted after EXPORT_TTL.
+func exportTTL() time.Duration {
+	return utils.DurationFromEnv("EXPORT_TTL", 24*time.Hour)
+}
+
+// @description Marks export jobs which were pending or running when the server stopped as failed, they are never
+// finished otherwise. It is run at startup, before any job is started.
+func (c Controller) FailInterruptedExports() error {
+	_, err := c.DB.Collection("exports").UpdateMany(
+		context.TODO(),
+		bson.D{{Key: "status", Value: bson.M{"$in": bson.A{models.ExportPending, models.ExportRunning}}}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: models.ExportFailed},
+			{Key: "error", Value: "Export is interrupted, request a new one."},
+			{Key: "completedat", Value: time.Now()},
+		}}},
+	)
+
+	return err
+}
+
+// @description Expires exports finished before EXPORT_TTL periodically until the context is done.
+func (c Controller) RunExportCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.expireExports(); err != nil {
+			log.Println("Error while expiring exports:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// @description Deletes archives of exports finished before EXPORT_TTL and marks them expired.
+func (c Controller) expireExports() error {
+	var exports []models.Export
+
+	filter := bson.D{
+		{Key: "status", Value: models.ExportDone},
+		{Key: "completedat", Value: bson.M{"$lte": time.Now().Add(-exportTTL())}},
+	}
+
+	cursor, err := c.DB.Collection("exports").Find(context.TODO(), filter)
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(context.TODO(), &exports); err != nil {
+		return err
+	}
+
+	for _, export := range exports {
+		if err := os.Remove(export.File); err != nil && !os.IsNotExist(err) {
+			log.Println("Error while deleting export file:", export.File, err)
+			continue
+		}
+
+		_, err := c.DB.Collection("exports").UpdateOne(
+			context.TODO(),
+			bson.D{{Key: "_id", Value: export.ID}},
+			bson.D{
+				{Key: "$set", Value: bson.D{{Key: "status", Value: models.ExportExpired}}},
+				{Key: "$unset", Value: bson.D{{Key: "file", Value: ""}}},
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// @description Exports are stored in EXPORT_DIR, temporary directory is used when it is not set.
+func exportDirectory() string {
+	if directory := os.Getenv("EXPORT_DIR"); directory != "" {
+		return directory
+	}
+	return filepath.Join(os.TempDir(), "task-repetition-exports")
+}
+
+// @description Writes zip archive with export.json and CSV files of tasks, notes and reviews of user.
+func (c Controller) writeExportArchive(w io.Writer, userId primitive.ObjectID) error {
+	var user models.User
+	data := exportData{ExportedAt: time.Now()}
+
+	if err := c.DB.Collection("users").FindOne(context.TODO(), bson.D{{Key: "_id", Value: userId}}).Decode(&user); err != nil {
+		return err
+	}
+	data.Profile = models.NewProfile(user)
+
+	filter := bson.D{{Key: "user", Value: userId}}
+	for collection, result := range map[string]interface{}{
+		"tasks":           &data.Tasks,
+		"notes":           &data.Notes,
+		"reviews":         &data.Reviews,
+		"repetitiontypes": &data.RepetitionTypes,
+	} {
+		cursor, err := c.DB.Collection(collection).Find(context.TODO(), filter)
+		if err != nil {
+			return err
+		}
+		if err = cursor.All(context.TODO(), result); err != nil {
+			return err
+		}
+	}
+
+	archive := zip.NewWriter(w)
+
+	file, err := archive.Create("export.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return err
+	}
+
+	tasks := [][]string{{"ID", "Title", "Link", "Summary", "Tags", "RepetitionType", "RepetitionBeginDay", "CompletedDay", "LastReviewDay"}}
+	for _, task := range data.Tasks {
+		tasks = append(tasks, []string{
+			task.ID.Hex(), task.Title, task.Link, task.Summary, strings.Join(task.Tags, ";"),
+			objectIdCell(task.RepetitionType), timeCell(task.RepetitionBeginDay), timeCell(task.CompletedDay), timeCell(task.LastReviewDay),
+		})
+	}
+
+	notes := [][]string{{"ID", "Task", "Note", "Important"}}
+	for _, note := range data.Notes {
+		notes = append(notes, []string{note.ID.Hex(), note.Task.Hex(), note.Note, strconv.FormatBool(note.Important)})
+	}
+
+	reviews := [][]string{{"ID", "Task", "RepetitionType", "Interval", "Recalled", "ReviewedAt"}}
+	for _, review := range data.Reviews {
+		reviews = append(reviews, []string{
+			review.ID.Hex(), review.Task.Hex(), objectIdCell(review.RepetitionType),
+			strconv.FormatFloat(review.Interval, 'f', 2, 64), strconv.FormatBool(review.Recalled), timeCell(review.ReviewedAt),
+		})
+	}
+
+	for name, records := range map[string][][]string{"tasks.csv": tasks, "notes.csv": notes, "reviews.csv": reviews} {
+		file, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		if err := csv.NewWriter(file).WriteAll(records); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func objectIdCell(id primitive.ObjectID) string {
+	if id.IsZero() {
+		return ""
+	}
+	return id.Hex()
+}
+
+func timeCell(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/auth"
+	"github.com/bberkgulay/task-repetition-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errIdentityEmailNotVerified = errors.New("email of the identity is not verified")
+	errIdentityRegistration     = errors.New("registration of identities is closed")
+)
+
+// @description Verifies password of user with the stored hash. If a directory is configured, the password of unknown
+// users, users without a password and users of the directory is checked by the directory and the local user of the
+// directory entry is returned.
+func (c Controller) verifyPassword(username string, password string, r *http.Request) (models.User, bool) {
+	var user models.User
+
+	err := c.DB.Collection("users").FindOne(context.TODO(), bson.D{{Key: "email", Value: normalizeEmail(username)}}).Decode(&user)
+	if err == nil && user.Password != "" {
+		if auth.CompareHashAndPassword(user.Password, password) {
+			c.rehashPassword(user, password)
+			return user, true
+		}
+		//a wrong local password is not sent to the directory, unless the user comes from it.
+		if !c.isDirectoryUser(user) {
+			return user, false
+		}
+	}
+
+	if c.Directory == nil {
+		return user, false
+	}
+
+	entry, err := c.Directory.Authenticate(username, password)
+	if err != nil {
+		if err != auth.ErrInvalidCredentials {
+			log.Println("Error while authenticating with directory:", err)
+		}
+		return user, false
+	}
+
+	//the directory is trusted for the email of its entries.
+	user, err = c.linkIdentity(
+		r,
+		models.Identity{Issuer: entry.Directory, Subject: entry.DN},
+		models.User{Name: entry.Name, Surname: entry.Surname, Email: entry.Email, EmailVerified: true},
+	)
+	if err == errIdentityRegistration {
+		return user, false
+	}
+	if err != nil {
+		log.Println("Error while linking directory user:", err)
+		return user, false
+	}
+
+	return user, true
+}
+
+// @description Confirms the password of the logged in user, e.g. before deleting the account. Users of a directory
+// confirm with their directory password, the directory is searched by their email.
+func (c Controller) confirmPassword(user models.User, password string, r *http.Request) bool {
+	verified, ok := c.verifyPassword(user.Email, password, r)
+	return ok && verified.ID == user.ID
+}
+
+// @description Reports whether user has an identity of the configured directory.
+func (c Controller) isDirectoryUser(user models.User) bool {
+	if c.Directory == nil {
+		return false
+	}
+	for _, identity := range user.Identities {
+		if identity.Issuer == c.Directory.Name() {
+			return true
+		}
+	}
+	return false
+}
+
+// @description Returns the user of the external identity. An unknown identity is linked to the user with the verified
+// email of profile or a new user is created from profile if identity registration is allowed.
+func (c Controller) linkIdentity(r *http.Request, identity models.Identity, profile models.User) (models.User, error) {
+	var user models.User
+
+	filter := bson.D{{Key: "identities", Value: bson.M{"$elemMatch": bson.M{"issuer": identity.Issuer, "subject": identity.Subject}}}}
+	err := c.DB.Collection("users").FindOne(context.TODO(), filter).Decode(&user)
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	//accounts are only linked by an email which the provider verified.
+	if profile.Email == "" || !profile.EmailVerified {
+		return user, errIdentityEmailNotVerified
+	}
+	profile.Email = normalizeEmail(profile.Email)
+
+	user, err = c.linkLocalUser(r, identity, profile.Email)
+	if err == nil {
+		return user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	if !identityRegistration() {
+		return user, errIdentityRegistration
+	}
+
+	//users created from an identity have no password, they can set one with forgot password.
+	user = models.User{
+		Name:          profile.Name,
+		Surname:       profile.Surname,
+		Email:         profile.Email,
+		EmailVerified: true,
+		Roles:         []string{auth.RoleUser},
+		Identities:    []models.Identity{identity},
+	}
+
+	insertResult, err := c.DB.Collection("users").InsertOne(context.TODO(), user)
+	if err != nil {
+		return user, err
+	}
+	user.ID = insertResult.InsertedID.(primitive.ObjectID)
+
+	c.audit(r, AuditRegister, user.ID, map[string]string{"method": "identity", "issuer": identity.Issuer})
+
+	return user, nil
+}
+
+// @description Links identity to the local user with email, mongo.ErrNoDocuments if there is none. The email of
+// an unverified local account may have been registered by someone else before its owner, so the account is taken over by
+// the identity: its password and second factor are removed and its sessions and tokens are revoked.
+func (c Controller) linkLocalUser(r *http.Request, identity models.Identity, email string) (models.User, error) {
+	var user models.User
+
+	if err := c.DB.Collection("users").FindOne(context.TODO(), bson.D{{Key: "email", Value: email}}).Decode(&user); err != nil {
+		return user, err
+	}
+
+	takeover := !user.EmailVerified
+
+	filter := bson.D{{Key: "_id", Value: user.ID}, {Key: "emailverified", Value: true}}
+	update := bson.D{
+		{Key: "$addToSet", Value: bson.D{{Key: "identities", Value: identity}}},
+		{Key: "$set", Value: bson.D{{Key: "emailverified", Value: true}}},
+	}
+	if takeover {
+		filter[1].Value = bson.M{"$ne": true}
+		update = append(update, bson.E{Key: "$unset", Value: bson.D{
+			{Key: "password", Value: ""},
+			{Key: "totpsecret", Value: ""},
+			{Key: "totpenabled", Value: ""},
+			{Key: "totplaststep", Value: ""},
+			{Key: "recoverycodes", Value: ""},
+		}})
+	}
+
+	//the filter fails if the account is verified in between, then the link is tried again.
+	err := c.DB.Collection("users").FindOneAndUpdate(
+		context.TODO(),
+		filter,
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return c.linkLocalUser(r, identity, email)
+	}
+	if err != nil {
+		return user, err
+	}
+
+	details := map[string]string{"issuer": identity.Issuer, "subject": identity.Subject}
+
+	if takeover {
+		c.Credentials.InvalidateUser(user.ID.Hex())
+
+		if _, err := c.revokeSessions(bson.D{{Key: "user", Value: user.ID}}); err != nil {
+			return user, err
+		}
+
+		_, err = c.DB.Collection("apitokens").UpdateMany(
+			context.TODO(),
+			bson.D{{Key: "user", Value: user.ID}, {Key: "revokedat", Value: bson.M{"$exists": false}}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "revokedat", Value: time.Now()}}}},
+		)
+		if err != nil {
+			return user, err
+		}
+
+		details["takeover"] = "true"
+	}
+
+	c.audit(r, AuditIdentityLinked, user.ID, details)
+
+	return user, nil
+}
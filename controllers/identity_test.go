@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/auth"
+	"github.com/bberkgulay/task-repetition-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fakeDirectory is an in-process auth.Directory with entries by username and password.
+type fakeDirectory struct {
+	entries map[string]auth.DirectoryEntry
+	// passwords by username.
+	passwords map[string]string
+	// usernames asked for, in order.
+	asked []string
+}
+
+func (d *fakeDirectory) Name() string {
+	return "ldap://directory.example.com"
+}
+
+func (d *fakeDirectory) Authenticate(username string, password string) (*auth.DirectoryEntry, error) {
+	d.asked = append(d.asked, username)
+
+	entry, ok := d.entries[username]
+	if !ok || password == "" || d.passwords[username] != password {
+		return nil, auth.ErrInvalidCredentials
+	}
+	entry.Directory = d.Name()
+	return &entry, nil
+}
+
+// testController returns a controller using an empty database of the MongoDB server at TEST_CONNECTION_URL.
+// Tests are skipped if it is not set.
+func testController(t *testing.T) Controller {
+	connectionUrl := os.Getenv("TEST_CONNECTION_URL")
+	if connectionUrl == "" {
+		t.Skip("TEST_CONNECTION_URL is not set")
+	}
+
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(connectionUrl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	database := client.Database("test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		database.Drop(context.TODO())
+		client.Disconnect(context.TODO())
+	})
+
+	return Controller{DB: database}
+}
+
+func insertUser(t *testing.T, c Controller, user models.User) models.User {
+	result, err := c.DB.Collection("users").InsertOne(context.TODO(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return user
+}
+
+func findUser(t *testing.T, c Controller, id primitive.ObjectID) models.User {
+	var user models.User
+	if err := c.DB.Collection("users").FindOne(context.TODO(), bson.D{{Key: "_id", Value: id}}).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func newDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		entries: map[string]auth.DirectoryEntry{
+			"ada@example.com": {DN: "uid=ada,ou=users,dc=example,dc=com", Email: "Ada@Example.com", Name: "Ada", Surname: "Lovelace"},
+		},
+		passwords: map[string]string{"ada@example.com": "directory password"},
+	}
+}
+
+func TestVerifyPasswordProvisionsDirectoryUser(t *testing.T) {
+	c := testController(t)
+	directory := newDirectory()
+	c.Directory = directory
+	r := httptest.NewRequest("POST", "/api/v1/auth/login", nil)
+
+	if _, ok := c.verifyPassword("ada@example.com", "wrong", r); ok {
+		t.Fatal("verifyPassword() with a wrong password = true")
+	}
+
+	user, ok := c.verifyPassword("ada@example.com", "directory password", r)
+	if !ok {
+		t.Fatal("verifyPassword() with the directory password = false")
+	}
+
+	stored := findUser(t, c, user.ID)
+	if stored.Email != "ada@example.com" || !stored.EmailVerified || stored.Password != "" || stored.Name != "Ada" {
+		t.Errorf("provisioned user = %+v", stored)
+	}
+	if len(stored.Identities) != 1 || stored.Identities[0] != (models.Identity{Issuer: directory.Name(), Subject: "uid=ada,ou=users,dc=example,dc=com"}) {
+		t.Errorf("provisioned user identities = %+v", stored.Identities)
+	}
+
+	again, ok := c.verifyPassword("ada@example.com", "directory password", r)
+	if !ok || again.ID != user.ID {
+		t.Errorf("second verifyPassword() = %v, %v, want the provisioned user", again.ID, ok)
+	}
+	if count, _ := c.DB.Collection("users").CountDocuments(context.TODO(), bson.D{}); count != 1 {
+		t.Errorf("%d users after two logins, want 1", count)
+	}
+
+	if !c.confirmPassword(user, "directory password", r) {
+		t.Error("confirmPassword() of a directory user = false")
+	}
+	if c.confirmPassword(user, "wrong", r) {
+		t.Error("confirmPassword() of a directory user with a wrong password = true")
+	}
+}
+
+func TestVerifyPasswordIdentityRegistration(t *testing.T) {
+	tests := []struct {
+		mode        string
+		explicit    string
+		provisioned bool
+	}{
+		{RegistrationOpen, "", true},
+		{RegistrationInvite, "", false},
+		{RegistrationClosed, "", false},
+		{RegistrationClosed, "true", true},
+		{RegistrationOpen, "false", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode+" "+test.explicit, func(t *testing.T) {
+			t.Setenv("REGISTRATION_MODE", test.mode)
+			t.Setenv("IDENTITY_REGISTRATION", test.explicit)
+
+			c := testController(t)
+			c.Directory = newDirectory()
+			r := httptest.NewRequest("POST", "/api/v1/auth/login", nil)
+
+			if _, ok := c.verifyPassword("ada@example.com", "directory password", r); ok != test.provisioned {
+				t.Errorf("verifyPassword() = %v, want %v", ok, test.provisioned)
+			}
+			if count, _ := c.DB.Collection("users").CountDocuments(context.TODO(), bson.D{}); (count == 1) != test.provisioned {
+				t.Errorf("%d users, want provisioned %v", count, test.provisioned)
+			}
+
+			if test.provisioned {
+				return
+			}
+
+			//existing users are linked in every mode.
+			local := insertUser(t, c, models.User{Email: "ada@example.com", EmailVerified: true})
+			if user, ok := c.verifyPassword("ada@example.com", "directory password", r); !ok || user.ID != local.ID {
+				t.Errorf("verifyPassword() of an existing user = %v, want the user", ok)
+			}
+		})
+	}
+}
+
+func TestLinkIdentity(t *testing.T) {
+	tests := []struct {
+		name string
+		user models.User
+		// the local password is kept when the user verified the email.
+		keepsPassword bool
+	}{
+		{"verified local user", models.User{Email: "ada@example.com", Password: auth.HashPassword("local password"), EmailVerified: true}, true},
+		{"unverified local user", models.User{Email: "ada@example.com", Password: auth.HashPassword("local password"), TOTPEnabled: true, TOTPSecret: "secret"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testController(t)
+			c.Directory = newDirectory()
+			r := httptest.NewRequest("POST", "/api/v1/auth/login", nil)
+
+			local := insertUser(t, c, test.user)
+			c.DB.Collection("sessions").InsertOne(context.TODO(), bson.D{{Key: "user", Value: local.ID}, {Key: "createdat", Value: time.Now()}})
+
+			user, err := c.linkIdentity(
+				r,
+				models.Identity{Issuer: c.Directory.Name(), Subject: "uid=ada,ou=users,dc=example,dc=com"},
+				models.User{Email: "Ada@Example.com", EmailVerified: true},
+			)
+			if err != nil || user.ID != local.ID {
+				t.Fatalf("linkIdentity() = %v, %v, want the local user", user.ID, err)
+			}
+
+			stored := findUser(t, c, local.ID)
+			if len(stored.Identities) != 1 || !stored.EmailVerified {
+				t.Errorf("linked user = %+v", stored)
+			}
+			if (stored.Password != "") != test.keepsPassword {
+				t.Errorf("linked user has password %v, want %v", stored.Password != "", test.keepsPassword)
+			}
+			if !test.keepsPassword && (stored.TOTPEnabled || stored.TOTPSecret != "") {
+				t.Error("second factor of a taken over user is kept")
+			}
+
+			revoked, _ := c.DB.Collection("sessions").CountDocuments(context.TODO(), bson.D{{Key: "user", Value: local.ID}, {Key: "revokedat", Value: bson.M{"$exists": true}}})
+			if (revoked == 0) != test.keepsPassword {
+				t.Errorf("%d sessions revoked, want revoked %v", revoked, !test.keepsPassword)
+			}
+		})
+	}
+}
+
+func TestVerifyPasswordAsksDirectoryOnlyForItsUsers(t *testing.T) {
+	c := testController(t)
+	directory := newDirectory()
+	c.Directory = directory
+	r := httptest.NewRequest("POST", "/api/v1/auth/login", nil)
+
+	local := insertUser(t, c, models.User{Email: "grace@example.com", Password: auth.HashPassword("local password"), EmailVerified: true})
+
+	if _, ok := c.verifyPassword("grace@example.com", "wrong", r); ok {
+		t.Error("verifyPassword() with a wrong password = true")
+	}
+	if user, ok := c.verifyPassword("grace@example.com", "local password", r); !ok || user.ID != local.ID {
+		t.Error("verifyPassword() with the local password = false")
+	}
+	if len(directory.asked) != 0 {
+		t.Errorf("directory is asked for %v, want a local user to be checked locally", directory.asked)
+	}
+
+	//a directory user with a local password is checked by the directory when the local one does not match.
+	c.DB.Collection("users").UpdateOne(
+		context.TODO(),
+		bson.D{{Key: "_id", Value: local.ID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "identities", Value: []models.Identity{{Issuer: directory.Name(), Subject: "uid=grace"}}}}}},
+	)
+	c.verifyPassword("grace@example.com", "wrong", r)
+	if strings.Join(directory.asked, ",") != "grace@example.com" {
+		t.Errorf("directory is asked for %v, want grace@example.com", directory.asked)
+	}
+}
@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/auth"
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// registration modes, set with REGISTRATION_MODE.
+const (
+	RegistrationOpen   = "open"
+	RegistrationClosed = "closed"
+	RegistrationInvite = "invite"
+)
+
+// @description Returns the registration mode, open unless REGISTRATION_MODE is closed or invite.
+func registrationMode() string {
+	switch mode := os.Getenv("REGISTRATION_MODE"); mode {
+	case RegistrationClosed, RegistrationInvite:
+		return mode
+	default:
+		return RegistrationOpen
+	}
+}
+
+// @description Reports whether unknown single sign-on and directory users get an account on their first login.
+// IDENTITY_REGISTRATION=true or false sets it explicitly, otherwise it follows the registration mode: only open
+// registration creates them, as they can not send an invite code.
+func identityRegistration() bool {
+	switch os.Getenv("IDENTITY_REGISTRATION") {
+	case "true":
+		return true
+	case "false":
+		return false
+	default:
+		return registrationMode() == RegistrationOpen
+	}
+}
+
+// @route       POST /api/v1/admin/invites
+// @access      Admin
+// @description Creates invite code for registration. MaxUses is 1 by default, Email and ExpiresAt are optional.
+// The code is returned only once.
+func (c Controller) CreateInvite() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var invite models.Invite
+		var error models.Error
+
+		json.NewDecoder(r.Body).Decode(&invite)
+
+		if invite.MaxUses < 0 {
+			error.Message = "MaxUses must be positive."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+		if invite.MaxUses == 0 {
+			invite.MaxUses = 1
+		}
+
+		invite.Email = normalizeEmail(invite.Email)
+		if invite.Email != "" && !isValidEmail(invite.Email) {
+			error.Message = "Enter a valid email address."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		if !invite.ExpiresAt.IsZero() && invite.ExpiresAt.Before(time.Now()) {
+			error.Message = "Expiry must be in the future."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		adminId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		code, codeHash, err := auth.NewOpaqueToken()
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		invite.ID = primitive.NilObjectID
+		invite.Prefix = code[:6]
+		invite.CodeHash = codeHash
+		invite.Uses = 0
+		invite.UsedBy = nil
+		invite.CreatedBy = adminId
+		invite.CreatedAt = time.Now()
+		invite.LastUsedAt = time.Time{}
+		invite.RevokedAt = time.Time{}
+
+		insertResult, err := c.DB.Collection("invites").InsertOne(context.TODO(), invite)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		invite.ID = insertResult.InsertedID.(primitive.ObjectID)
+		invite.Code = code
+
+		c.audit(r, AuditInviteCreated, primitive.NilObjectID, map[string]string{"invite": invite.ID.Hex()})
+
+		utils.SendSuccess(w, invite)
+	}
+}
+
+// @route       GET /api/v1/admin/invites
+// @access      Admin
+// @description Returns invite codes with their usage, newest first.
+func (c Controller) GetInvites() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var invites []models.Invite
+		var error models.Error
+
+		queryOptions := options.FindOptions{}
+		queryOptions.SetSort(bson.D{{Key: "createdat", Value: -1}})
+
+		cursor, err := c.DB.Collection("invites").Find(context.TODO(), bson.D{}, &queryOptions)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if err = cursor.All(context.TODO(), &invites); err != nil {
+			error.Message = "Error while parsing data."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		utils.SendSuccess(w, invites)
+	}
+}
+
+// @route       DELETE /api/v1/admin/invites/{id}
+// @access      Admin
+// @description Revokes invite code by id, it can not be used anymore.
+func (c Controller) RevokeInvite() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+		params := mux.Vars(r)
+
+		id, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			error.Message = "Incorrect ID value."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		result, err := c.DB.Collection("invites").UpdateOne(
+			context.TODO(),
+			bson.D{{Key: "_id", Value: id}, {Key: "revokedat", Value: bson.M{"$exists": false}}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "revokedat", Value: time.Now()}}}},
+		)
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			error.Message = "No invite to revoke"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		c.audit(r, AuditInviteRevoked, primitive.NilObjectID, map[string]string{"invite": id.Hex()})
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
+
+// @description Uses the invite code for registration of user. Returns false if the code is unknown, revoked, expired,
+// used up or for another email. The use is counted atomically so that a code can not be used more than MaxUses times.
+func (c Controller) useInvite(code string, userId primitive.ObjectID, email string) (primitive.ObjectID, bool) {
+	var invite models.Invite
+
+	now := time.Now()
+	filter := bson.D{
+		{Key: "codehash", Value: auth.HashToken(code)},
+		{Key: "revokedat", Value: bson.M{"$exists": false}},
+		{Key: "$and", Value: bson.A{
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "expiresat", Value: bson.M{"$exists": false}}},
+				bson.D{{Key: "expiresat", Value: bson.M{"$gt": now}}},
+			}}},
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "email", Value: bson.M{"$exists": false}}},
+				bson.D{{Key: "email", Value: email}},
+			}}},
+		}},
+		{Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{"$uses", "$maxuses"}}}},
+	}
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "uses", Value: 1}}},
+		{Key: "$push", Value: bson.D{{Key: "usedby", Value: userId}}},
+		{Key: "$set", Value: bson.D{{Key: "lastusedat", Value: now}}},
+	}
+
+	err := c.DB.Collection("invites").FindOneAndUpdate(context.TODO(), filter, update).Decode(&invite)
+
+	return invite.ID, err == nil
+}
+
+// @description Gives back the use of the invite when the registration fails after the code is used.
+func (c Controller) releaseInvite(inviteId primitive.ObjectID, userId primitive.ObjectID) {
+	c.DB.Collection("invites").UpdateOne(
+		context.TODO(),
+		bson.D{{Key: "_id", Value: inviteId}},
+		bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "uses", Value: -1}}},
+			{Key: "$pull", Value: bson.D{{Key: "usedby", Value: userId}}},
+		},
+	)
+}
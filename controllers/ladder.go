@@ -0,0 +1,338 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/scheduler"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// default minimum number of reviews before a step of the ladder is changed by the optimizer.
+const defaultMinReviews = 5
+
+// @route       POST /api/v1/repetitiontypes/optimize
+// @access      Private
+// @description Fits repetition ladder of user to the review history and proposes optimized day offsets.
+// Optional body {"targetRetention": 0.9, "minReviews": 5}
+func (c Controller) OptimizeRepetitionTypes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+		var repetitionTypes []models.RepetitionType
+		var knownRepetitionTypes []models.RepetitionType
+		var reviews []models.Review
+		var request struct {
+			TargetRetention float64
+			MinReviews      int
+		}
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.TargetRetention == 0 {
+			request.TargetRetention = scheduler.DefaultTargetRetention
+		}
+		if request.TargetRetention <= 0 || request.TargetRetention >= 1 {
+			error.Message = "Target retention must be between 0 and 1."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+		if request.MinReviews <= 0 {
+			request.MinReviews = defaultMinReviews
+		}
+
+		userId, hexError := currentUserID(r)
+		if hexError != nil {
+			error.Message = "Error occurred about user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		ladder, err := c.ladderFilter(userId)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		cursor, err := c.DB.Collection("repetitiontypes").Find(context.TODO(), ladder)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+		if err = cursor.All(context.TODO(), &repetitionTypes); err != nil {
+			error.Message = "Error while parsing data."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if len(repetitionTypes) == 0 {
+			error.Message = "There is no repetition type to optimize."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		//reviews may reference shared or own repetition types, both are mapped to their order in the ladder.
+		knownFilter := bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "user", Value: userId}},
+			bson.D{{Key: "user", Value: bson.M{"$exists": false}}},
+		}}}
+		cursor, err = c.DB.Collection("repetitiontypes").Find(context.TODO(), knownFilter)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+		if err = cursor.All(context.TODO(), &knownRepetitionTypes); err != nil {
+			error.Message = "Error while parsing data."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		orders := map[primitive.ObjectID]int{}
+		for _, repetitionType := range knownRepetitionTypes {
+			orders[repetitionType.ID] = repetitionType.Order
+		}
+
+		cursor, err = c.DB.Collection("reviews").Find(context.TODO(), bson.D{{Key: "user", Value: userId}})
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+		if err = cursor.All(context.TODO(), &reviews); err != nil {
+			error.Message = "Error while parsing data."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		observations := map[int][]scheduler.Observation{}
+		for _, review := range reviews {
+			order, ok := orders[review.RepetitionType]
+			if !ok {
+				continue
+			}
+			observations[order] = append(observations[order], scheduler.Observation{Elapsed: review.Interval, Recalled: review.Recalled})
+		}
+
+		steps := make([]scheduler.Step, 0, len(repetitionTypes))
+		for _, repetitionType := range repetitionTypes {
+			steps = append(steps, scheduler.Step{Order: repetitionType.Order, Name: repetitionType.Name, Day: repetitionType.Day})
+		}
+
+		optimized := scheduler.Optimize(steps, observations, request.TargetRetention, request.MinReviews)
+
+		proposal := models.LadderProposal{
+			User:            userId,
+			TargetRetention: optimized.TargetRetention,
+			RetentionBefore: optimized.RetentionBefore,
+			RetentionAfter:  optimized.RetentionAfter,
+			CreatedAt:       time.Now(),
+		}
+		for _, step := range optimized.Steps {
+			proposal.Steps = append(proposal.Steps, models.LadderProposalStep(step))
+		}
+
+		insertResult, err := c.DB.Collection("ladderproposals").InsertOne(context.TODO(), proposal)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+		proposal.ID = insertResult.InsertedID.(primitive.ObjectID)
+
+		utils.SendSuccess(w, proposal)
+	}
+}
+
+// @route       PUT /api/v1/repetitiontypes/proposals/{id}/accept
+// @access      Private
+// @description Replaces repetition ladder of user with the proposed one. Tasks and reviews are moved to the new steps by order.
+func (c Controller) AcceptLadderProposal() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+		var proposal models.LadderProposal
+		var oldRepetitionTypes []models.RepetitionType
+
+		params := mux.Vars(r)
+
+		proposalId, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			error.Message = "Incorrect ID value."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "_id", Value: proposalId}, {Key: "user", Value: userId}}
+		if err := c.DB.Collection("ladderproposals").FindOne(context.TODO(), filter).Decode(&proposal); err != nil {
+			error.Message = "There is no proposal with this ID of user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		if !proposal.AcceptedAt.IsZero() {
+			error.Message = "Proposal is already accepted."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		ladder, err := c.ladderFilter(userId)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		cursor, err := c.DB.Collection("repetitiontypes").Find(context.TODO(), ladder)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+		if err = cursor.All(context.TODO(), &oldRepetitionTypes); err != nil {
+			error.Message = "Error while parsing data."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		//a proposal is only valid for the ladder it is fitted to.
+		if !proposalMatchesLadder(proposal, oldRepetitionTypes) {
+			error.Message = "Repetition types are changed since the proposal, optimize them again."
+			utils.SendError(w, http.StatusConflict, error)
+			return
+		}
+
+		newRepetitionTypes := make([]models.RepetitionType, 0, len(proposal.Steps))
+		documents := make([]interface{}, 0, len(proposal.Steps))
+		for _, step := range proposal.Steps {
+			repetitionType := models.RepetitionType{
+				ID:    primitive.NewObjectID(),
+				Name:  step.Name,
+				Order: step.Order,
+				Day:   step.ProposedDay,
+				User:  userId,
+			}
+			newRepetitionTypes = append(newRepetitionTypes, repetitionType)
+			documents = append(documents, repetitionType)
+		}
+
+		//proposal is claimed first so that it is accepted only once, the claim is released if accepting fails.
+		claimFilter := bson.D{{Key: "_id", Value: proposalId}, {Key: "user", Value: userId}, {Key: "acceptedat", Value: bson.M{"$exists": false}}}
+		claim, err := c.DB.Collection("ladderproposals").UpdateOne(
+			context.TODO(),
+			claimFilter,
+			bson.D{{Key: "$set", Value: bson.D{{Key: "acceptedat", Value: time.Now()}}}},
+		)
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+		if claim.MatchedCount == 0 {
+			error.Message = "Proposal is already accepted."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		//new steps are added before anything is moved or deleted, so that a failure leaves the old ladder in use.
+		if err := c.replaceLadder(userId, oldRepetitionTypes, newRepetitionTypes, documents); err != nil {
+			c.DB.Collection("ladderproposals").UpdateOne(
+				context.TODO(),
+				filter,
+				bson.D{{Key: "$unset", Value: bson.D{{Key: "acceptedat", Value: ""}}}},
+			)
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		utils.SendSuccess(w, newRepetitionTypes)
+	}
+}
+
+// @description Reports whether the ladder is still the one the proposal is fitted to, with the same days by order.
+func proposalMatchesLadder(proposal models.LadderProposal, ladder []models.RepetitionType) bool {
+	if len(proposal.Steps) != len(ladder) {
+		return false
+	}
+
+	days := map[int]int{}
+	for _, repetitionType := range ladder {
+		days[repetitionType.Order] = repetitionType.Day
+	}
+
+	for _, step := range proposal.Steps {
+		day, ok := days[step.Order]
+		if !ok || day != step.CurrentDay {
+			return false
+		}
+	}
+	return true
+}
+
+// @description Replaces the old ladder of user with the new one. New steps are inserted, tasks and reviews are moved to
+// them by order and then the old steps of user are deleted. If moving fails, what is moved is moved back and the new
+// steps are deleted, so the old ladder stays complete.
+func (c Controller) replaceLadder(userId primitive.ObjectID, oldRepetitionTypes []models.RepetitionType, newRepetitionTypes []models.RepetitionType, documents []interface{}) error {
+	if _, err := c.DB.Collection("repetitiontypes").InsertMany(context.TODO(), documents); err != nil {
+		return err
+	}
+
+	newIds := make([]primitive.ObjectID, 0, len(newRepetitionTypes))
+	for _, repetitionType := range newRepetitionTypes {
+		newIds = append(newIds, repetitionType.ID)
+	}
+
+	if err := c.moveToLadder(userId, oldRepetitionTypes, newRepetitionTypes); err != nil {
+		c.moveToLadder(userId, newRepetitionTypes, oldRepetitionTypes)
+		c.DB.Collection("repetitiontypes").DeleteMany(context.TODO(), bson.D{{Key: "_id", Value: bson.M{"$in": newIds}}})
+		return err
+	}
+
+	//shared repetition types have no user and are kept.
+	oldIds := make([]primitive.ObjectID, 0, len(oldRepetitionTypes))
+	for _, repetitionType := range oldRepetitionTypes {
+		oldIds = append(oldIds, repetitionType.ID)
+	}
+	_, err := c.DB.Collection("repetitiontypes").DeleteMany(context.TODO(), bson.D{
+		{Key: "user", Value: userId},
+		{Key: "_id", Value: bson.M{"$in": oldIds}},
+	})
+
+	return err
+}
+
+// @description Points tasks and reviews of user from the steps of one ladder to the steps with the same order in the other.
+func (c Controller) moveToLadder(userId primitive.ObjectID, from []models.RepetitionType, to []models.RepetitionType) error {
+	for _, fromRepetitionType := range from {
+		for _, toRepetitionType := range to {
+			if fromRepetitionType.Order != toRepetitionType.Order {
+				continue
+			}
+
+			moveFilter := bson.D{{Key: "user", Value: userId}, {Key: "repetitiontype", Value: fromRepetitionType.ID}}
+			update := bson.D{{Key: "$set", Value: bson.D{{Key: "repetitiontype", Value: toRepetitionType.ID}}}}
+
+			if _, err := c.DB.Collection("tasks").UpdateMany(context.TODO(), moveFilter, withNewVersion(update)); err != nil {
+				return err
+			}
+			if _, err := c.DB.Collection("reviews").UpdateMany(context.TODO(), moveFilter, update); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxLoginBackoff = 5 * time.Minute
+
+// loginPolicy decides how failed logins of a key are throttled. After backoffAfter failures every
+// further attempt waits exponentially longer, after lockAfter failures the key is locked.
+type loginPolicy struct {
+	byAccount    bool
+	prefix       string
+	backoffAfter int
+	lockAfter    int
+	lockDuration time.Duration
+	lockStatus   int
+}
+
+// loginThrottle is the result of a throttle check, zero Status means the attempt is allowed.
+type loginThrottle struct {
+	Status     int
+	RetryAfter time.Duration
+	Failures   int
+}
+
+func accountLoginPolicy() loginPolicy {
+	return loginPolicy{
+		byAccount:    true,
+		prefix:       "account:",
+		backoffAfter: 3,
+		lockAfter:    utils.IntFromEnv("LOCKOUT_THRESHOLD", 10),
+		lockDuration: utils.DurationFromEnv("LOCKOUT_DURATION", 15*time.Minute),
+		lockStatus:   http.StatusLocked,
+	}
+}
+
+func ipLoginPolicy() loginPolicy {
+	return loginPolicy{
+		prefix:       "ip:",
+		backoffAfter: 10,
+		lockAfter:    utils.IntFromEnv("IP_LOCKOUT_THRESHOLD", 50),
+		lockDuration: utils.DurationFromEnv("LOCKOUT_DURATION", 15*time.Minute),
+		lockStatus:   http.StatusTooManyRequests,
+	}
+}
+
+// key returns the login attempt key of the account or of the client IP.
+func (policy loginPolicy) key(email string, r *http.Request) string {
+	if policy.byAccount {
+		return policy.prefix + strings.ToLower(email)
+	}
+	return policy.prefix + utils.ClientIP(r)
+}
+
+// @description Checks whether a login attempt for the account from the client IP is allowed right now.
+func (c Controller) checkLoginThrottle(email string, r *http.Request) loginThrottle {
+	var result loginThrottle
+
+	now := time.Now()
+	for _, policy := range []loginPolicy{accountLoginPolicy(), ipLoginPolicy()} {
+		var attempt models.LoginAttempt
+
+		key := policy.key(email, r)
+		err := c.DB.Collection("loginattempts").FindOne(context.TODO(), bson.D{{Key: "_id", Value: key}}).Decode(&attempt)
+		if err != nil {
+			continue
+		}
+		if policy.byAccount {
+			result.Failures = attempt.Failures
+		}
+
+		status, retryAfter := 0, time.Duration(0)
+		if attempt.LockedUntil.After(now) {
+			status, retryAfter = policy.lockStatus, attempt.LockedUntil.Sub(now)
+		} else if attempt.Failures >= policy.backoffAfter {
+			backoff := time.Duration(math.Pow(2, float64(attempt.Failures-policy.backoffAfter))) * time.Second
+			if backoff > maxLoginBackoff {
+				backoff = maxLoginBackoff
+			}
+			if next := attempt.LastFailureAt.Add(backoff); next.After(now) {
+				status, retryAfter = http.StatusTooManyRequests, next.Sub(now)
+			}
+		}
+
+		//the longest wait wins, a locked account is reported before a throttled IP.
+		if status != 0 && (result.Status == 0 || retryAfter > result.RetryAfter || status == http.StatusLocked) {
+			result.Status, result.RetryAfter = status, retryAfter
+		}
+	}
+
+	return result
+}
+
+// @description Counts failed login of the account and the client IP, locks them when the threshold is reached.
+func (c Controller) recordLoginFailure(email string, r *http.Request) {
+	var user models.User
+
+	//failures of unknown emails are audited too, without user.
+	c.DB.Collection("users").FindOne(
+		context.TODO(),
+		bson.D{{Key: "email", Value: email}},
+		options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}}),
+	).Decode(&user)
+	c.audit(r, AuditLoginFailed, user.ID, map[string]string{"email": email})
+
+	now := time.Now()
+	for _, policy := range []loginPolicy{accountLoginPolicy(), ipLoginPolicy()} {
+		var attempt models.LoginAttempt
+
+		key := policy.key(email, r)
+		queryOptions := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		err := c.DB.Collection("loginattempts").FindOneAndUpdate(
+			context.TODO(),
+			bson.D{{Key: "_id", Value: key}},
+			bson.D{
+				{Key: "$inc", Value: bson.D{{Key: "failures", Value: 1}}},
+				{Key: "$set", Value: bson.D{{Key: "lastfailureat", Value: now}}},
+			},
+			queryOptions,
+		).Decode(&attempt)
+		if err != nil || attempt.Failures < policy.lockAfter {
+			continue
+		}
+
+		if policy.byAccount {
+			c.Credentials.InvalidateUsername(email)
+		}
+		c.audit(r, AuditLoginLocked, user.ID, map[string]string{"key": key, "until": now.Add(policy.lockDuration).Format(time.RFC3339)})
+
+		c.DB.Collection("loginattempts").UpdateOne(
+			context.TODO(),
+			bson.D{{Key: "_id", Value: key}},
+			bson.D{{Key: "$set", Value: bson.D{
+				{Key: "failures", Value: 0},
+				{Key: "lockeduntil", Value: now.Add(policy.lockDuration)},
+			}}},
+		)
+	}
+}
+
+// @description Clears failed logins of the account after a successful login.
+func (c Controller) resetLoginFailures(email string) {
+	key := accountLoginPolicy().key(email, nil)
+	c.DB.Collection("loginattempts").DeleteOne(context.TODO(), bson.D{{Key: "_id", Value: key}})
+}
+
+// @description Sends 423 or 429 response with Retry-After header for a throttled login.
+func sendLoginThrottled(w http.ResponseWriter, throttle loginThrottle) {
+	var error models.Error
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttle.RetryAfter.Seconds()))))
+
+	if throttle.Status == http.StatusLocked {
+		error.Message = "Account is temporarily locked because of too many failed logins."
+	} else {
+		error.Message = "Too many failed logins. Try again later."
+	}
+	utils.SendError(w, throttle.Status, error)
+}
+
+// @route       POST /api/v1/admin/unlock
+// @access      Admin
+// @description Clears failed logins and lock of an account and/or IP address. Body {"email": "...", "ip": "..."}
+func (c Controller) UnlockLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+		var request struct {
+			Email string
+			IP    string
+		}
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.Email == "" && request.IP == "" {
+			error.Message = "Enter missing fields. (Email or IP)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		keys := bson.A{}
+		if request.Email != "" {
+			keys = append(keys, accountLoginPolicy().prefix+strings.ToLower(request.Email))
+		}
+		if request.IP != "" {
+			keys = append(keys, ipLoginPolicy().prefix+request.IP)
+		}
+
+		result, err := c.DB.Collection("loginattempts").DeleteMany(context.TODO(), bson.D{{Key: "_id", Value: bson.M{"$in": keys}}})
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.audit(r, AuditLoginUnlocked, primitive.NilObjectID, map[string]string{"email": request.Email, "ip": request.IP})
+
+		utils.SendSuccess(w, result)
+	}
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/bberkgulay/task-repetition-go/models"
 	"github.com/bberkgulay/task-repetition-go/utils"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // @route       POST /api/v1/tasks/{task_id}/notes
@@ -28,8 +31,8 @@ func (c Controller) AddNote() http.HandlerFunc {
 			return
 		}
 
-		//Getting user from header for owner control.
-		userId, hexError := primitive.ObjectIDFromHex(r.Header.Get("userID"))
+		//Getting user from request context for owner control.
+		userId, hexError := currentUserID(r)
 		if hexError != nil {
 			error.Message = "Error occurred getting user."
 			utils.SendError(w, http.StatusBadRequest, error)
@@ -61,6 +64,9 @@ func (c Controller) AddNote() http.HandlerFunc {
 			return
 		}
 
+		note.Version = 1
+		note.UpdatedAt = time.Now()
+
 		insertResult, err := c.DB.Collection("notes").InsertOne(context.TODO(), note)
 
 		if err != nil {
@@ -69,6 +75,7 @@ func (c Controller) AddNote() http.HandlerFunc {
 			return
 		}
 
+		w.Header().Set("ETag", utils.ETag(note.Version))
 		utils.SendSuccess(w, insertResult.InsertedID)
 	}
 }
@@ -81,8 +88,8 @@ func (c Controller) GetNotes() http.HandlerFunc {
 		var error models.Error
 		var notes []models.Note
 
-		//Getting user from header for owner control.
-		userId, hexError := primitive.ObjectIDFromHex(r.Header.Get("userID"))
+		//Getting user from request context for owner control.
+		userId, hexError := currentUserID(r)
 		if hexError != nil {
 			error.Message = "Error occurred about notes."
 			utils.SendError(w, http.StatusBadRequest, error)
@@ -114,20 +121,121 @@ func (c Controller) GetNotes() http.HandlerFunc {
 			return
 		}
 
-		utils.SendSuccess(w, notes)
+		utils.SendWithETag(w, r, "", notes)
 	}
 }
 
 // @route       GET /api/v1/notes/{id}
 // @access      Private
+// @description Gets note by id with owner control.
+func (c Controller) GetNote() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var note models.Note
+		var error models.Error
+		params := mux.Vars(r)
+
+		//Getting user from request context for owner control.
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		id, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			error.Message = "Incorrect ID value."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "user", Value: userId}, {Key: "_id", Value: id}}
+
+		err = c.DB.Collection("notes").FindOne(context.TODO(), filter).Decode(&note)
+		if err == mongo.ErrNoDocuments {
+			error.Message = "No note with this ID."
+			utils.SendError(w, http.StatusNotFound, error)
+			return
+		}
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		utils.SendWithETag(w, r, utils.ETag(note.Version), note)
+	}
+}
+
+// @route       PUT /api/v1/notes/{id}
+// @access      Private
+// @description Updates text and importance of note by id with owner control and returns the updated note.
+func (c Controller) UpdateNote() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body models.Note
+		var note models.Note
+		var error models.Error
+		params := mux.Vars(r)
+
+		json.NewDecoder(r.Body).Decode(&body)
+
+		if body.Note == "" {
+			error.Message = "Enter missing fields. (Note)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		//Getting user from request context for owner control.
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		id, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			error.Message = "Incorrect ID value."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "user", Value: userId}, {Key: "_id", Value: id}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "note", Value: body.Note}, {Key: "important", Value: true}}}}
+		if !body.Important {
+			update = bson.D{
+				{Key: "$set", Value: bson.D{{Key: "note", Value: body.Note}}},
+				{Key: "$unset", Value: bson.D{{Key: "important", Value: ""}}},
+			}
+		}
+		queryOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		err = c.DB.Collection("notes").FindOneAndUpdate(context.TODO(), withIfMatch(r, filter), withNewVersion(update), queryOptions).Decode(&note)
+		if err == mongo.ErrNoDocuments {
+			c.sendUnmatched(w, "notes", filter, http.StatusNotFound, "No note with this ID.")
+			return
+		}
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		w.Header().Set("ETag", utils.ETag(note.Version))
+		utils.SendSuccess(w, note)
+	}
+}
+
+// @route       DELETE /api/v1/notes/{id}
+// @access      Private
 // @description Deletes note by id with owner control.
 func (c Controller) DeleteNote() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var error models.Error
 		params := mux.Vars(r)
 
-		//Getting user from header for owner control.
-		userId, err := primitive.ObjectIDFromHex(r.Header.Get("userID"))
+		//Getting user from request context for owner control.
+		userId, err := currentUserID(r)
 		if err != nil {
 			error.Message = "Error while getting user."
 			utils.SendError(w, http.StatusBadRequest, error)
@@ -143,11 +251,15 @@ func (c Controller) DeleteNote() http.HandlerFunc {
 
 		filter := bson.D{{Key: "user", Value: userId}, {Key: "_id", Value: id}}
 
-		result := c.DB.Collection("notes").FindOneAndDelete(context.TODO(), filter).Err()
+		result := c.DB.Collection("notes").FindOneAndDelete(context.TODO(), withIfMatch(r, filter)).Err()
 
+		if result == mongo.ErrNoDocuments {
+			c.sendUnmatched(w, "notes", filter, http.StatusBadRequest, "No note to delete")
+			return
+		}
 		if result != nil {
-			error.Message = "No note to delete"
-			utils.SendError(w, http.StatusBadRequest, error)
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
 			return
 		}
 
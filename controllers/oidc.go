@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/auth"
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// time the user has to finish the login at the provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// @route       GET /api/v1/auth/oidc/login
+// @access      Public
+// @description Starts single sign-on login with the authorization code flow and PKCE, redirects to the provider.
+func (c Controller) OIDCLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+
+		if c.OIDC == nil {
+			error.Message = "Single sign-on is not configured."
+			utils.SendError(w, http.StatusNotFound, error)
+			return
+		}
+
+		state, stateHash, err := auth.NewOpaqueToken()
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		nonce, _, err := auth.NewOpaqueToken()
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		codeVerifier, codeChallenge, err := auth.NewPKCE()
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		redirectURL, err := c.OIDC.AuthCodeURL(r.Context(), state, nonce, codeChallenge)
+		if err != nil {
+			log.Println("Error while starting single sign-on:", err)
+			error.Message = "Single sign-on provider is not available."
+			utils.SendError(w, http.StatusBadGateway, error)
+			return
+		}
+
+		now := time.Now()
+
+		//logins which are never finished are cleaned here.
+		c.DB.Collection("oidclogins").DeleteMany(context.TODO(), bson.D{{Key: "expiresat", Value: bson.M{"$lt": now}}})
+
+		_, err = c.DB.Collection("oidclogins").InsertOne(context.TODO(), models.OIDCLogin{
+			StateHash:    stateHash,
+			Nonce:        nonce,
+			CodeVerifier: codeVerifier,
+			CreatedAt:    now,
+			ExpiresAt:    now.Add(oidcLoginTTL),
+		})
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+	}
+}
+
+// @route       GET /api/v1/auth/oidc/callback
+// @access      Public
+// @description Finishes single sign-on login. The identity is linked to the user with the same verified email,
+// a user is created if there is none and identity registration is allowed. Returns tokens like Login, or a second factor challenge for users with
+// two-factor authentication.
+func (c Controller) OIDCCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var login models.OIDCLogin
+		var error models.Error
+
+		if c.OIDC == nil {
+			error.Message = "Single sign-on is not configured."
+			utils.SendError(w, http.StatusNotFound, error)
+			return
+		}
+
+		query := r.URL.Query()
+
+		if providerError := query.Get("error"); providerError != "" {
+			error.Message = "Single sign-on failed: " + providerError
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
+
+		if query.Get("code") == "" || query.Get("state") == "" {
+			error.Message = "Enter missing fields. (code, state)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{
+			{Key: "statehash", Value: auth.HashToken(query.Get("state"))},
+			{Key: "user", Value: bson.M{"$exists": false}},
+			{Key: "expiresat", Value: bson.M{"$gt": time.Now()}},
+		}
+		if err := c.DB.Collection("oidclogins").FindOneAndDelete(context.TODO(), filter).Decode(&login); err != nil {
+			error.Message = "Invalid or expired login state"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		claims, err := c.OIDC.Exchange(r.Context(), query.Get("code"), login.CodeVerifier, login.Nonce)
+		if err != nil {
+			log.Println("Error while finishing single sign-on:", err)
+			error.Message = "Single sign-on failed."
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
+
+		name := claims.GivenName
+		if name == "" {
+			name = claims.Name
+		}
+
+		user, err := c.linkIdentity(
+			r,
+			models.Identity{Issuer: claims.Issuer, Subject: claims.Subject},
+			models.User{Name: name, Surname: claims.FamilyName, Email: claims.Email, EmailVerified: claims.EmailVerified},
+		)
+		if err == errIdentityEmailNotVerified {
+			error.Message = "Email of your single sign-on account is not verified."
+			utils.SendError(w, http.StatusForbidden, error)
+			return
+		}
+		if err == errIdentityRegistration {
+			error.Message = "Registration is closed."
+			utils.SendError(w, http.StatusForbidden, error)
+			return
+		}
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if user.TOTPEnabled && user.DeletedAt.IsZero() && !user.Disabled {
+			c.challengeSecondFactor(w, user)
+			return
+		}
+
+		c.finishOIDCLogin(w, r, user)
+	}
+}
+
+// @description Keeps the single sign-on login of user waiting for the second factor and returns its challenge.
+func (c Controller) challengeSecondFactor(w http.ResponseWriter, user models.User) {
+	var error models.Error
+
+	token, tokenHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		error.Message = "Server Error"
+		utils.SendError(w, http.StatusInternalServerError, error)
+		return
+	}
+
+	now := time.Now()
+	login := models.OIDCLogin{
+		StateHash: tokenHash,
+		User:      user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(oidcLoginTTL),
+	}
+	if _, err := c.DB.Collection("oidclogins").InsertOne(context.TODO(), login); err != nil {
+		error.Message = "Server Error"
+		utils.SendError(w, http.StatusInternalServerError, error)
+		return
+	}
+
+	utils.SendSuccess(w, models.SecondFactorChallenge{SecondFactorToken: token, ExpiresAt: login.ExpiresAt})
+}
+
+// @route       POST /api/v1/auth/oidc/second-factor
+// @access      Public
+// @description Finishes single sign-on login of a user with two-factor authentication with the challenge token of the
+// callback and a code or recovery code. The challenge can be used once, a wrong code requires a new single sign-on login.
+func (c Controller) OIDCSecondFactor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var login models.OIDCLogin
+		var user models.User
+		var error models.Error
+		var request struct {
+			SecondFactorToken string
+			Code              string
+			RecoveryCode      string
+		}
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.SecondFactorToken == "" || (request.Code == "" && request.RecoveryCode == "") {
+			error.Message = "Enter missing fields. (SecondFactorToken, Code or RecoveryCode)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{
+			{Key: "statehash", Value: auth.HashToken(request.SecondFactorToken)},
+			{Key: "user", Value: bson.M{"$exists": true}},
+			{Key: "expiresat", Value: bson.M{"$gt": time.Now()}},
+		}
+		if err := c.DB.Collection("oidclogins").FindOneAndDelete(context.TODO(), filter).Decode(&login); err != nil {
+			error.Message = "Invalid or expired second factor challenge"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
+
+		if err := c.DB.Collection("users").FindOne(context.TODO(), bson.D{{Key: "_id", Value: login.User}}).Decode(&user); err != nil {
+			error.Message = "Invalid or expired second factor challenge"
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
+
+		throttle := c.checkLoginThrottle(user.Email, r)
+		if throttle.Status != 0 {
+			sendLoginThrottled(w, throttle)
+			return
+		}
+
+		credentials := models.Credentials{Email: user.Email, Code: request.Code, RecoveryCode: request.RecoveryCode}
+		if !c.verifySecondFactor(user, credentials) {
+			c.recordLoginFailure(user.Email, r)
+			error.Message = "Incorrect two-factor code."
+			utils.SendError(w, http.StatusUnauthorized, error)
+			return
+		}
+
+		if throttle.Failures > 0 {
+			c.resetLoginFailures(user.Email)
+		}
+
+		c.finishOIDCLogin(w, r, user)
+	}
+}
+
+// @description Starts a session of user logged in with single sign-on and returns its tokens.
+func (c Controller) finishOIDCLogin(w http.ResponseWriter, r *http.Request, user models.User) {
+	var error models.Error
+
+	if !user.DeletedAt.IsZero() {
+		error.Message = "Account is deleted. It can be restored until " + user.DeletedAt.Add(accountDeletionGrace()).Format(time.RFC3339) + "."
+		utils.SendError(w, http.StatusForbidden, error)
+		return
+	}
+
+	if user.Disabled {
+		error.Message = "Account is disabled."
+		utils.SendError(w, http.StatusForbidden, error)
+		return
+	}
+
+	sessionId, err := c.startSession(user, r)
+	if err != nil {
+		error.Message = "Server Error"
+		utils.SendError(w, http.StatusInternalServerError, error)
+		return
+	}
+
+	tokens, err := c.issueTokens(user, sessionId, primitive.NewObjectID())
+	if err != nil {
+		error.Message = "Server Error"
+		utils.SendError(w, http.StatusInternalServerError, error)
+		return
+	}
+
+	c.audit(r, AuditLoginSucceeded, user.ID, map[string]string{"method": "oidc", "session": sessionId.Hex()})
+
+	utils.SendSuccess(w, tokens)
+}
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/auth"
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// @route       POST /api/v1/auth/forgot-password
+// @access      Public
+// @description Sends password reset link to email of user. It always succeeds so that registered emails can not be discovered.
+func (c Controller) ForgotPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request models.User
+		var user models.User
+		var error models.Error
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.Email == "" {
+			error.Message = "Enter missing fields. (Email)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "email", Value: normalizeEmail(request.Email)}, {Key: "deletedat", Value: bson.M{"$exists": false}}}
+		if err := c.DB.Collection("users").FindOne(context.TODO(), filter).Decode(&user); err != nil {
+			utils.SendSuccess(w, "Successful")
+			return
+		}
+
+		//the link is created and mailed after the response, so that its time does not tell registered emails apart. A copy
+		//of the request is kept for the audit log.
+		go c.sendPasswordReset(r.Clone(context.Background()), user)
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
+
+// @description Creates password reset token of user and mails the link, earlier links are invalidated. Failures are
+// only logged since the response is already sent.
+func (c Controller) sendPasswordReset(r *http.Request, user models.User) {
+	token, tokenHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		log.Println("Error while creating password reset token:", err)
+		return
+	}
+
+	now := time.Now()
+
+	//only the last requested link can be used.
+	_, err = c.DB.Collection("passwordresets").UpdateMany(
+		context.TODO(),
+		bson.D{{Key: "user", Value: user.ID}, {Key: "usedat", Value: bson.M{"$exists": false}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "usedat", Value: now}}}},
+	)
+	if err != nil {
+		log.Println("Error while invalidating password reset tokens:", err)
+		return
+	}
+
+	_, err = c.DB.Collection("passwordresets").InsertOne(context.TODO(), models.PasswordReset{
+		User:      user.ID,
+		TokenHash: tokenHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(utils.DurationFromEnv("PASSWORD_RESET_TTL", time.Hour)),
+	})
+	if err != nil {
+		log.Println("Error while storing password reset token:", err)
+		return
+	}
+
+	body := "Hello " + user.Name + ",\n\n" +
+		"Use the link below to reset your password. It can be used once and expires soon.\n\n" +
+		os.Getenv("APP_URL") + "/reset-password?token=" + url.QueryEscape(token) + "\n\n" +
+		"If you did not ask for a password reset, you can ignore this email.\n"
+
+	if err := c.Mailer.Send(user.Email, "Reset your password", body); err != nil {
+		log.Println("Error while sending password reset email:", err)
+		return
+	}
+
+	c.audit(r, AuditPasswordResetSent, user.ID, nil)
+}
+
+// @route       POST /api/v1/auth/reset-password
+// @access      Public
+// @description Sets new password of user with a reset token. All logins of user are revoked.
+func (c Controller) ResetPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var passwordReset models.PasswordReset
+		var user models.User
+		var error models.Error
+		var request struct {
+			Token    string
+			Password string
+		}
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.Token == "" || request.Password == "" {
+			error.Message = "Enter missing fields. (Token, Password)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		now := time.Now()
+		filter := bson.D{
+			{Key: "tokenhash", Value: auth.HashToken(request.Token)},
+			{Key: "usedat", Value: bson.M{"$exists": false}},
+			{Key: "expiresat", Value: bson.M{"$gt": now}},
+		}
+
+		//password is checked against the email of the user before the token is used, so a rejected password can be retried.
+		if err := c.DB.Collection("passwordresets").FindOne(context.TODO(), filter).Decode(&passwordReset); err != nil {
+			error.Message = "Invalid or expired reset token"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+		if err := c.DB.Collection("users").FindOne(context.TODO(), bson.D{{Key: "_id", Value: passwordReset.User}}).Decode(&user); err != nil {
+			error.Message = "Invalid or expired reset token"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		if err := auth.ValidatePassword(request.Password, user.Email); err != nil {
+			error.Message = err.Error()
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		hashedPassword := auth.HashPassword(request.Password)
+		if hashedPassword == "" {
+			error.Message = "Error while hashing password."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		//token is marked as used in the same operation, so it can not be used twice.
+		err := c.DB.Collection("passwordresets").FindOneAndUpdate(
+			context.TODO(),
+			filter,
+			bson.D{{Key: "$set", Value: bson.D{{Key: "usedat", Value: now}}}},
+		).Decode(&passwordReset)
+		if err != nil {
+			error.Message = "Invalid or expired reset token"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		err = c.DB.Collection("users").FindOneAndUpdate(
+			context.TODO(),
+			bson.D{{Key: "_id", Value: passwordReset.User}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: hashedPassword}}}},
+		).Decode(&user)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.Credentials.InvalidateUser(user.ID.Hex())
+
+		if _, err := c.revokeSessions(bson.D{{Key: "user", Value: user.ID}}); err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+		c.resetLoginFailures(user.Email)
+
+		c.audit(r, AuditPasswordReset, user.ID, nil)
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
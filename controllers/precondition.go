@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// @description Adds the If-Match condition of the request to filter of a change, so that only the versions the client
+// has seen are changed. Without If-Match or with If-Match: * filter is returned as it is.
+func withIfMatch(r *http.Request, filter bson.D) bson.D {
+	versions, ok := ifMatchVersions(r)
+	if !ok {
+		return filter
+	}
+
+	return append(filter, versionIn(versions...))
+}
+
+// @description Reports whether If-Match of the request allows changing the version of a document which is already read.
+func ifMatches(r *http.Request, version int64) bool {
+	versions, ok := ifMatchVersions(r)
+	if !ok {
+		return true
+	}
+
+	for _, matching := range versions {
+		if matching == version {
+			return true
+		}
+	}
+	return false
+}
+
+// @description Returns the versions in If-Match of the request, false if any version may be changed. Weak and unknown
+// tags never match.
+func ifMatchVersions(r *http.Request) ([]int64, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return nil, false
+	}
+
+	var versions []int64
+	for _, tag := range strings.Split(header, ",") {
+		if version, ok := utils.ParseETag(tag); ok {
+			versions = append(versions, version)
+		}
+	}
+	return versions, true
+}
+
+// @description Returns the condition of documents with one of the versions. Documents written before versions were
+// counted have no version, they are version 0.
+func versionIn(versions ...int64) bson.E {
+	values := bson.A{}
+	for _, version := range versions {
+		values = append(values, version)
+		if version == 0 {
+			values = append(values, nil)
+		}
+	}
+
+	return bson.E{Key: "version", Value: bson.M{"$in": values}}
+}
+
+// @description Adds counting a new version of the document to update, for updates without pipeline.
+func withNewVersion(update bson.D) bson.D {
+	update = append(update, bson.E{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}})
+
+	for i, operator := range update {
+		if set, ok := operator.Value.(bson.D); ok && operator.Key == "$set" {
+			update[i].Value = append(set, bson.E{Key: "updatedat", Value: time.Now()})
+			return update
+		}
+	}
+
+	return append(update, bson.E{Key: "$set", Value: bson.D{{Key: "updatedat", Value: time.Now()}}})
+}
+
+// @description Returns the update pipeline stage which counts a new version of a document.
+func newVersionStage() bson.D {
+	return bson.D{{Key: "$set", Value: bson.D{
+		{Key: "version", Value: bson.D{{Key: "$add", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$version", 0}}}, 1}}}},
+		{Key: "updatedat", Value: time.Now()},
+	}}}
+}
+
+// @description Sends the error of a conditional change which matched nothing. If the document of filter exists, its
+// version did not match If-Match and 412 Precondition Failed is sent, otherwise status with message.
+func (c Controller) sendUnmatched(w http.ResponseWriter, collection string, filter bson.D, status int, message string) {
+	var error models.Error
+
+	count, err := c.DB.Collection(collection).CountDocuments(context.TODO(), filter)
+	if err != nil {
+		error.Message = "Server error"
+		utils.SendError(w, http.StatusInternalServerError, error)
+		return
+	}
+
+	if count > 0 {
+		error.Message = "It was changed in the meantime, get it again."
+		utils.SendError(w, http.StatusPreconditionFailed, error)
+		return
+	}
+
+	error.Message = message
+	utils.SendError(w, status, error)
+}
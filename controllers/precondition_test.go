@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestIfMatchVersions(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		versions []int64
+		ok       bool
+	}{
+		{"no header", "", nil, false},
+		{"any", " * ", nil, false},
+		{"one version", `"3"`, []int64{3}, true},
+		{"versions", `"3", "5"`, []int64{3, 5}, true},
+		//weak and unknown tags never match, so a header of only those matches no version.
+		{"weak tag", `W/"3"`, nil, true},
+		{"unknown tag among versions", `"abc", "4"`, []int64{4}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/api/v1/tasks/1", nil)
+			if test.header != "" {
+				r.Header.Set("If-Match", test.header)
+			}
+
+			versions, ok := ifMatchVersions(r)
+			if !reflect.DeepEqual(versions, test.versions) || ok != test.ok {
+				t.Errorf("ifMatchVersions() = %v, %v, want %v, %v", versions, ok, test.versions, test.ok)
+			}
+
+			for _, version := range []int64{3, 4} {
+				want := !test.ok
+				for _, matching := range test.versions {
+					want = want || matching == version
+				}
+				if got := ifMatches(r, version); got != want {
+					t.Errorf("ifMatches(%d) = %v, want %v", version, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestVersionIn(t *testing.T) {
+	//documents written before versions were counted have no version, they match version 0.
+	want := bson.E{Key: "version", Value: bson.M{"$in": bson.A{int64(0), nil, int64(2)}}}
+	if got := versionIn(0, 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("versionIn(0, 2) = %v, want %v", got, want)
+	}
+}
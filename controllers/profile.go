@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/bberkgulay/task-repetition-go/auth"
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// @route       GET /api/v1/me
+// @access      Private
+// @description Returns profile of user.
+func (c Controller) GetProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user models.User
+		var error models.Error
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		if err := c.DB.Collection("users").FindOne(context.TODO(), bson.D{{Key: "_id", Value: userId}}).Decode(&user); err != nil {
+			error.Message = "Server Error."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		utils.SendSuccess(w, models.NewProfile(user))
+	}
+}
+
+// @route       PUT /api/v1/me
+// @access      Private
+// @description Updates name and surname of user. Email and password have their own endpoints.
+func (c Controller) UpdateProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request models.User
+		var user models.User
+		var error models.Error
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.Name == "" || request.Surname == "" {
+			error.Message = "Enter missing fields. (Name, Surname)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		err = c.DB.Collection("users").FindOneAndUpdate(
+			context.TODO(),
+			bson.D{{Key: "_id", Value: userId}},
+			bson.D{{Key: "$set", Value: bson.D{
+				{Key: "name", Value: request.Name},
+				{Key: "surname", Value: request.Surname},
+			}}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&user)
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		utils.SendSuccess(w, models.NewProfile(user))
+	}
+}
+
+// @route       PUT /api/v1/me/password
+// @access      Private
+// @description Changes password of user after checking the current one. Other sessions of user are revoked.
+func (c Controller) ChangePassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user models.User
+		var error models.Error
+		var request struct {
+			CurrentPassword string
+			NewPassword     string
+		}
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.CurrentPassword == "" || request.NewPassword == "" {
+			error.Message = "Enter missing fields. (CurrentPassword, NewPassword)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "_id", Value: userId}}
+		if err := c.DB.Collection("users").FindOne(context.TODO(), filter).Decode(&user); err != nil {
+			error.Message = "Server Error."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if !c.confirmPassword(user, request.CurrentPassword, r) {
+			error.Message = "Current password is incorrect."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		if err := auth.ValidatePassword(request.NewPassword, user.Email); err != nil {
+			error.Message = err.Error()
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		hashedPassword := auth.HashPassword(request.NewPassword)
+		if hashedPassword == "" {
+			error.Message = "Error while hashing password."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		_, err = c.DB.Collection("users").UpdateOne(
+			context.TODO(),
+			filter,
+			bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: hashedPassword}}}},
+		)
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.Credentials.InvalidateUser(userId.Hex())
+
+		//other devices have to login with the new password.
+		if _, err := c.revokeSessions(otherSessionsFilter(userId, r)); err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.audit(r, AuditPasswordChanged, userId, nil)
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
+
+// @route       PUT /api/v1/me/email
+// @access      Private
+// @description Sends verification link to the new email, email of user is changed when it is verified.
+func (c Controller) ChangeEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user models.User
+		var error models.Error
+		var request struct {
+			Email    string
+			Password string
+		}
+
+		json.NewDecoder(r.Body).Decode(&request)
+		request.Email = normalizeEmail(request.Email)
+
+		if request.Email == "" || request.Password == "" {
+			error.Message = "Enter missing fields. (Email, Password)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		if !isValidEmail(request.Email) {
+			error.Message = "Enter a valid email address."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		if err := c.DB.Collection("users").FindOne(context.TODO(), bson.D{{Key: "_id", Value: userId}}).Decode(&user); err != nil {
+			error.Message = "Server Error."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if !c.confirmPassword(user, request.Password, r) {
+			error.Message = "Password is incorrect."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		existedUser, err := c.DB.Collection("users").CountDocuments(context.TODO(), bson.D{{Key: "email", Value: request.Email}})
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+		if existedUser > 0 {
+			error.Message = "That email is taken. Try another."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		if err := c.sendEmailVerification(user, request.Email); err != nil {
+			error.Message = "Error while sending email."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.audit(r, AuditEmailChangeSent, userId, map[string]string{"email": request.Email})
+
+		utils.SendSuccess(w, "Verification email is sent to the new address.")
+	}
+}
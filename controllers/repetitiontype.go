@@ -2,11 +2,14 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/bberkgulay/task-repetition-go/models"
 	"github.com/bberkgulay/task-repetition-go/utils"
+	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -20,7 +23,24 @@ func (c Controller) GetRepetitionTypes() http.HandlerFunc {
 		var error models.Error
 		var repetitionTypes []models.RepetitionType
 
-		cursor, err := c.DB.Collection("repetitiontypes").Find(context.TODO(), bson.D{})
+		userId, hexError := currentUserID(r)
+		if hexError != nil {
+			error.Message = "Error occurred about user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter, err := c.ladderFilter(userId)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		queryOptions := options.FindOptions{}
+		queryOptions.SetSort(bson.D{{Key: "order", Value: 1}})
+
+		cursor, err := c.DB.Collection("repetitiontypes").Find(context.TODO(), filter, &queryOptions)
 
 		if err != nil {
 			error.Message = "Server Error"
@@ -38,8 +58,23 @@ func (c Controller) GetRepetitionTypes() http.HandlerFunc {
 	}
 }
 
+// @description Returns filter of repetition ladder used by user. Users who accepted an optimized ladder have their own repetition types, others use the shared ones.
+func (c Controller) ladderFilter(userId primitive.ObjectID) (bson.D, error) {
+	ownFilter := bson.D{{Key: "user", Value: userId}}
+
+	count, err := c.DB.Collection("repetitiontypes").CountDocuments(context.TODO(), ownFilter)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return ownFilter, nil
+	}
+
+	return bson.D{{Key: "user", Value: bson.M{"$exists": false}}}, nil
+}
+
 // @description Returns next repetitions type for task to calculate next RepetitionBeginDay and RepetitionType
-func (c Controller) GetNextRepetitionType(repetitionTypeId primitive.ObjectID) (*models.RepetitionType, error) {
+func (c Controller) GetNextRepetitionType(userId primitive.ObjectID, repetitionTypeId primitive.ObjectID) (*models.RepetitionType, error) {
 	var repetitionType *models.RepetitionType
 	var nextRepetitionType models.RepetitionType
 
@@ -56,10 +91,15 @@ func (c Controller) GetNextRepetitionType(repetitionTypeId primitive.ObjectID) (
 		order = repetitionType.Order
 	}
 
+	ladder, err := c.ladderFilter(userId)
+	if err != nil {
+		return nil, errors.New("error")
+	}
+
 	//finds the first repetition type greater than the order in the sorted list.
 	queryOptions := options.FindOneOptions{}
 	queryOptions.SetSort(bson.D{{Key: "order", Value: 1}})
-	filter := bson.D{{Key: "order", Value: bson.M{"$gt": order}}}
+	filter := append(ladder, bson.E{Key: "order", Value: bson.M{"$gt": order}})
 
 	findErr := c.DB.Collection("repetitiontypes").FindOne(context.TODO(), filter, &queryOptions).Decode(&nextRepetitionType)
 
@@ -70,3 +110,151 @@ func (c Controller) GetNextRepetitionType(repetitionTypeId primitive.ObjectID) (
 
 	return &nextRepetitionType, nil
 }
+
+// @route       POST /api/v1/admin/repetitiontypes
+// @access      Admin
+// @description Adds shared repetition type.
+func (c Controller) AddRepetitionType() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var repetitionType models.RepetitionType
+		var error models.Error
+
+		json.NewDecoder(r.Body).Decode(&repetitionType)
+
+		if repetitionType.Name == "" || repetitionType.Order <= 0 || repetitionType.Day <= 0 {
+			error.Message = "Enter missing fields. (Name, Order, Day)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		repetitionType.ID = primitive.NilObjectID
+		repetitionType.User = primitive.NilObjectID
+
+		filter := bson.D{{Key: "user", Value: bson.M{"$exists": false}}, {Key: "order", Value: repetitionType.Order}}
+		existedOrder, err := c.DB.Collection("repetitiontypes").CountDocuments(context.TODO(), filter)
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+		if existedOrder > 0 {
+			error.Message = "There is already a repetition type with this order."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		insertResult, err := c.DB.Collection("repetitiontypes").InsertOne(context.TODO(), repetitionType)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.audit(r, AuditRepetitionTypeAdded, primitive.NilObjectID, map[string]string{
+			"repetitiontype": insertResult.InsertedID.(primitive.ObjectID).Hex(),
+			"name":           repetitionType.Name,
+			"order":          strconv.Itoa(repetitionType.Order),
+			"day":            strconv.Itoa(repetitionType.Day),
+		})
+
+		utils.SendSuccess(w, insertResult.InsertedID)
+	}
+}
+
+// @route       PUT /api/v1/admin/repetitiontypes/{id}
+// @access      Admin
+// @description Updates name and day of shared repetition type. Order can not be changed as tasks follow it.
+func (c Controller) UpdateRepetitionType() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var repetitionType models.RepetitionType
+		var error models.Error
+
+		json.NewDecoder(r.Body).Decode(&repetitionType)
+
+		if repetitionType.Name == "" || repetitionType.Day <= 0 {
+			error.Message = "Enter missing fields. (Name, Day)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		params := mux.Vars(r)
+
+		id, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			error.Message = "Incorrect ID value."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "_id", Value: id}, {Key: "user", Value: bson.M{"$exists": false}}}
+		result, err := c.DB.Collection("repetitiontypes").UpdateOne(
+			context.TODO(),
+			filter,
+			bson.D{{Key: "$set", Value: bson.D{
+				{Key: "name", Value: repetitionType.Name},
+				{Key: "day", Value: repetitionType.Day},
+			}}},
+		)
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			error.Message = "There is no shared repetition type with this ID."
+			utils.SendError(w, http.StatusNotFound, error)
+			return
+		}
+
+		c.audit(r, AuditRepetitionTypeUpdated, primitive.NilObjectID, map[string]string{
+			"repetitiontype": id.Hex(),
+			"name":           repetitionType.Name,
+			"day":            strconv.Itoa(repetitionType.Day),
+		})
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
+
+// @route       DELETE /api/v1/admin/repetitiontypes/{id}
+// @access      Admin
+// @description Deletes shared repetition type which is not used by any task.
+func (c Controller) DeleteRepetitionType() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+		params := mux.Vars(r)
+
+		id, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			error.Message = "Incorrect ID value."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		usedBy, err := c.DB.Collection("tasks").CountDocuments(context.TODO(), bson.D{{Key: "repetitiontype", Value: id}})
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+		if usedBy > 0 {
+			error.Message = "Repetition type is used by tasks."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "_id", Value: id}, {Key: "user", Value: bson.M{"$exists": false}}}
+		result := c.DB.Collection("repetitiontypes").FindOneAndDelete(context.TODO(), filter).Err()
+
+		if result != nil {
+			error.Message = "No repetition type to delete"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		c.audit(r, AuditRepetitionTypeDeleted, primitive.NilObjectID, map[string]string{"repetitiontype": id.Hex()})
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"text/tabwriter"
+
+	"github.com/gorilla/mux"
+)
+
+// Access is the protection level of a route, it is enforced by LoginControl.
+type Access int
+
+const (
+	// Private routes need an authenticated user. This is the default for routes which are not registered.
+	Private Access = iota
+	// Public routes are served without authentication.
+	Public
+	// Admin routes need an authenticated user with the admin role.
+	Admin
+)
+
+func (a Access) String() string {
+	switch a {
+	case Public:
+		return "public"
+	case Admin:
+		return "admin"
+	default:
+		return "private"
+	}
+}
+
+// Route is an endpoint with its protection. Scope is required from personal access tokens, routes without a scope
+// reject them.
+type Route struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+	Access  Access
+	Scope   string
+}
+
+func (route Route) name() string {
+	return route.Method + " " + route.Path
+}
+
+// @description Registers routes to router under the prefix and keeps their protection for LoginControl.
+// All routes and their protection are listed in the log.
+func (c Controller) RegisterRoutes(router *mux.Router, prefix string, routes []Route) {
+	var listing bytes.Buffer
+	table := tabwriter.NewWriter(&listing, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "METHOD\tPATH\tACCESS\tSCOPE")
+
+	for _, route := range routes {
+		route.Path = prefix + route.Path
+		c.Routes[route.name()] = route
+
+		router.HandleFunc(route.Path, route.Handler).Methods(route.Method).Name(route.name())
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", route.Method, route.Path, route.Access, route.Scope)
+	}
+
+	table.Flush()
+	log.Printf("Routes:\n%s", listing.String())
+}
+
+// @description Returns registered route matched for the request. Unknown routes are private.
+func (c Controller) matchedRoute(r *http.Request) Route {
+	if current := mux.CurrentRoute(r); current != nil {
+		if route, ok := c.Routes[current.GetName()]; ok {
+			return route
+		}
+	}
+
+	return Route{Access: Private}
+}
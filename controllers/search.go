@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bberkgulay/task-repetition-go/auth"
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// length of highlighted fragments of long fields and the context kept before the first match.
+const (
+	fragmentLength  = 160
+	fragmentContext = 40
+)
+
+// matched word endings dropped from search terms, the text index matches the words by their stems.
+var searchSuffixes = []string{"ing", "ed", "es", "s", "ly"}
+
+// @route       GET /api/v1/search
+// @access      Private
+// @description Searches title, summary, tags and link of tasks and text of notes of user. Query parameters: q (words,
+// "phrases" and -excluded words), limit (default 20). Results are grouped by task and ordered by relevance.
+// Notes are only searched if the request may read notes.
+func (c Controller) Search() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		if q == "" {
+			error.Message = "Enter search query. (q)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 || limit > 100 {
+			limit = 20
+		}
+
+		//Getting user from request context, search is restricted to the data of user.
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		highlighter := newHighlighter(q)
+		results := map[primitive.ObjectID]*models.SearchResult{}
+
+		var tasks []struct {
+			models.Task `bson:",inline"`
+			Score       float64 `bson:"score"`
+		}
+		if err := c.textSearch("tasks", userId, q, limit, &tasks); err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		for _, task := range tasks {
+			result := &models.SearchResult{Task: task.Task, Score: task.Score}
+			result.Highlights = highlighter.fields(map[string][]string{
+				"title":   {task.Title},
+				"tags":    task.Tags,
+				"summary": {task.Summary},
+				"link":    {task.Link},
+			})
+			results[task.ID] = result
+		}
+
+		principal, _ := auth.PrincipalFrom(r)
+		if principal.HasScope(auth.ScopeNotesRead) {
+			var notes []struct {
+				models.Note `bson:",inline"`
+				Score       float64 `bson:"score"`
+			}
+			if err := c.textSearch("notes", userId, q, limit, &notes); err != nil {
+				error.Message = "Server Error"
+				utils.SendError(w, http.StatusInternalServerError, error)
+				return
+			}
+
+			//tasks of notes which are not found by themselves.
+			var missing []primitive.ObjectID
+			for _, note := range notes {
+				if _, ok := results[note.Task]; !ok {
+					missing = append(missing, note.Task)
+				}
+			}
+			if len(missing) > 0 {
+				var noteTasks []models.Task
+				filter := bson.D{{Key: "user", Value: userId}, {Key: "_id", Value: bson.M{"$in": missing}}}
+				cursor, err := c.DB.Collection("tasks").Find(context.TODO(), filter)
+				if err == nil {
+					err = cursor.All(context.TODO(), &noteTasks)
+				}
+				if err != nil {
+					error.Message = "Server Error"
+					utils.SendError(w, http.StatusInternalServerError, error)
+					return
+				}
+				for _, task := range noteTasks {
+					results[task.ID] = &models.SearchResult{Task: task}
+				}
+			}
+
+			for _, note := range notes {
+				//notes of deleted tasks are not shown.
+				result, ok := results[note.Task]
+				if !ok {
+					continue
+				}
+				result.Notes = append(result.Notes, models.NoteMatch{
+					Note:       note.Note,
+					Score:      note.Score,
+					Highlights: highlighter.fields(map[string][]string{"note": {note.Note.Note}}),
+				})
+				if note.Score > result.Score {
+					result.Score = note.Score
+				}
+			}
+		}
+
+		searchResults := make([]models.SearchResult, 0, len(results))
+		for _, result := range results {
+			searchResults = append(searchResults, *result)
+		}
+		sort.SliceStable(searchResults, func(i, j int) bool {
+			if searchResults[i].Score != searchResults[j].Score {
+				return searchResults[i].Score > searchResults[j].Score
+			}
+			return searchResults[i].Task.ID.Hex() > searchResults[j].Task.ID.Hex()
+		})
+		if len(searchResults) > limit {
+			searchResults = searchResults[:limit]
+		}
+
+		utils.SendSuccess(w, searchResults)
+	}
+}
+
+// @description Finds the documents of user in collection matching the text index best first, with their relevance as score.
+func (c Controller) textSearch(collection string, userId primitive.ObjectID, q string, limit int, results interface{}) error {
+	filter := bson.D{
+		{Key: "user", Value: userId},
+		{Key: "$text", Value: bson.D{{Key: "$search", Value: q}}},
+	}
+
+	score := bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}
+	queryOptions := options.Find().SetProjection(score).SetSort(score).SetLimit(int64(limit))
+
+	cursor, err := c.DB.Collection(collection).Find(context.TODO(), filter, queryOptions)
+	if err != nil {
+		return err
+	}
+
+	return cursor.All(context.TODO(), results)
+}
+
+// highlighter marks the words of a search query in text. Words are matched by their beginning so that other forms of
+// the word found by the text index are marked too, phrases are matched as they are.
+type highlighter struct {
+	pattern *regexp.Regexp
+}
+
+func newHighlighter(q string) highlighter {
+	var alternatives []string
+
+	for i, part := range strings.Split(q, `"`) {
+		//odd parts are between quotes.
+		if i%2 == 1 {
+			if phrase := strings.TrimSpace(part); phrase != "" {
+				alternatives = append(alternatives, regexp.QuoteMeta(phrase))
+			}
+			continue
+		}
+
+		for _, word := range strings.Fields(part) {
+			if strings.HasPrefix(word, "-") {
+				continue
+			}
+			word = strings.ToLower(word)
+			for _, suffix := range searchSuffixes {
+				if len(word)-len(suffix) >= 3 && strings.HasSuffix(word, suffix) {
+					word = strings.TrimSuffix(word, suffix)
+					//running is matched with run.
+					if n := len(word); n > 3 && word[n-1] == word[n-2] {
+						word = word[:n-1]
+					}
+					break
+				}
+			}
+			alternatives = append(alternatives, regexp.QuoteMeta(word)+`[\pL\pN]*`)
+		}
+	}
+
+	if len(alternatives) == 0 {
+		return highlighter{}
+	}
+
+	return highlighter{pattern: regexp.MustCompile(`(?i)` + strings.Join(alternatives, "|"))}
+}
+
+// @description Returns highlights of the values of fields which contain matched words.
+func (h highlighter) fields(fields map[string][]string) []models.Highlight {
+	var highlights []models.Highlight
+
+	for _, field := range []string{"title", "tags", "summary", "link", "note"} {
+		for _, value := range fields[field] {
+			if fragment, ok := h.highlight(value); ok {
+				highlights = append(highlights, models.Highlight{Field: field, Fragment: fragment})
+			}
+		}
+	}
+
+	return highlights
+}
+
+// @description Returns the fragment of text around the first match with all matches in it marked, false if nothing matches.
+func (h highlighter) highlight(text string) (string, bool) {
+	if h.pattern == nil {
+		return "", false
+	}
+
+	//only matches at the beginning of a word count.
+	var matches [][]int
+	for _, match := range h.pattern.FindAllStringIndex(text, -1) {
+		previous, _ := utf8.DecodeLastRuneInString(text[:match[0]])
+		if match[0] == 0 || !(unicode.IsLetter(previous) || unicode.IsDigit(previous)) {
+			matches = append(matches, match)
+		}
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	start, end := 0, len(text)
+	if len(text) > fragmentLength {
+		start = matches[0][0] - fragmentContext
+		if start < 0 {
+			start = 0
+		}
+		end = start + fragmentLength
+		if end > len(text) {
+			end = len(text)
+		}
+		for start > 0 && !utf8.RuneStart(text[start]) {
+			start--
+		}
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end--
+		}
+	}
+
+	var fragment strings.Builder
+	if start > 0 {
+		fragment.WriteString("…")
+	}
+	position := start
+	for _, match := range matches {
+		if match[0] < position || match[0] >= end {
+			continue
+		}
+		matchEnd := match[1]
+		if matchEnd > end {
+			matchEnd = end
+		}
+		fragment.WriteString(html.EscapeString(text[position:match[0]]))
+		fragment.WriteString("<mark>" + html.EscapeString(text[match[0]:matchEnd]) + "</mark>")
+		position = matchEnd
+	}
+	fragment.WriteString(html.EscapeString(text[position:end]))
+	if end < len(text) {
+		fragment.WriteString("…")
+	}
+
+	return fragment.String(), true
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/auth"
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// last seen time of a session is written at most once in this interval.
+const sessionTouchInterval = time.Minute
+
+// @route       GET /api/v1/me/sessions
+// @access      Private
+// @description Lists active sessions of user, the session of the request is marked as current.
+func (c Controller) GetSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var sessions []models.Session
+		var error models.Error
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{
+			{Key: "user", Value: userId},
+			{Key: "revokedat", Value: bson.M{"$exists": false}},
+			{Key: "expiresat", Value: bson.M{"$gt": time.Now()}},
+		}
+		cursor, err := c.DB.Collection("sessions").Find(context.TODO(), filter, options.Find().SetSort(bson.D{{Key: "lastseenat", Value: -1}}))
+		if err != nil {
+			error.Message = "Error while getting data."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if err = cursor.All(context.TODO(), &sessions); err != nil {
+			error.Message = "Error while parsing data."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		principal, _ := auth.PrincipalFrom(r)
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == principal.SessionID
+		}
+
+		utils.SendSuccess(w, sessions)
+	}
+}
+
+// @route       DELETE /api/v1/me/sessions/{id}
+// @access      Private
+// @description Revokes session of user by id, its refresh tokens and access tokens stop working.
+func (c Controller) RevokeSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+		params := mux.Vars(r)
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		id, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			error.Message = "Incorrect ID value."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		revoked, err := c.revokeSessions(bson.D{{Key: "_id", Value: id}, {Key: "user", Value: userId}})
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if revoked == 0 {
+			error.Message = "No session to revoke"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		c.audit(r, AuditSessionRevoked, userId, map[string]string{"session": id.Hex()})
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
+
+// @route       DELETE /api/v1/me/sessions
+// @access      Private
+// @description Revokes all sessions of user except the session of the request.
+func (c Controller) RevokeOtherSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		revoked, err := c.revokeSessions(otherSessionsFilter(userId, r))
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.audit(r, AuditSessionRevoked, userId, map[string]string{"sessions": strconv.FormatInt(revoked, 10)})
+
+		utils.SendSuccess(w, revoked)
+	}
+}
+
+// @description Starts a session for a new login of user on the requesting device and returns its ID.
+func (c Controller) startSession(user models.User, r *http.Request) (primitive.ObjectID, error) {
+	now := time.Now()
+	session := models.Session{
+		ID:         primitive.NewObjectID(),
+		User:       user.ID,
+		UserAgent:  r.UserAgent(),
+		IP:         utils.ClientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(auth.RefreshTokenTTL()),
+	}
+
+	_, err := c.DB.Collection("sessions").InsertOne(context.TODO(), session)
+
+	return session.ID, err
+}
+
+// @description Returns the session by id if it is not revoked or expired.
+func (c Controller) findActiveSession(id primitive.ObjectID) (models.Session, error) {
+	var session models.Session
+
+	filter := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "revokedat", Value: bson.M{"$exists": false}},
+		{Key: "expiresat", Value: bson.M{"$gt": time.Now()}},
+	}
+	err := c.DB.Collection("sessions").FindOne(context.TODO(), filter).Decode(&session)
+
+	return session, err
+}
+
+// @description Records activity of the session from the request. A refresh extends the session to the lifetime of the new refresh token.
+func (c Controller) touchSession(session models.Session, r *http.Request, refreshed bool) {
+	now := time.Now()
+	if !refreshed && now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return
+	}
+
+	update := bson.D{{Key: "lastseenat", Value: now}, {Key: "ip", Value: utils.ClientIP(r)}}
+	if refreshed {
+		update = append(update, bson.E{Key: "expiresat", Value: now.Add(auth.RefreshTokenTTL())})
+	}
+
+	c.DB.Collection("sessions").UpdateOne(
+		context.TODO(),
+		bson.D{{Key: "_id", Value: session.ID}},
+		bson.D{{Key: "$set", Value: update}},
+	)
+}
+
+// @description Revokes sessions matching the filter which are not revoked yet with their refresh tokens. Returns the number of revoked sessions.
+func (c Controller) revokeSessions(filter bson.D) (int64, error) {
+	var sessions []models.Session
+
+	filter = append(filter, bson.E{Key: "revokedat", Value: bson.M{"$exists": false}})
+
+	cursor, err := c.DB.Collection("sessions").Find(context.TODO(), filter, options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	if err = cursor.All(context.TODO(), &sessions); err != nil {
+		return 0, err
+	}
+	if len(sessions) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(sessions))
+	for i, session := range sessions {
+		ids[i] = session.ID
+	}
+
+	result, err := c.DB.Collection("sessions").UpdateMany(
+		context.TODO(),
+		bson.D{{Key: "_id", Value: bson.M{"$in": ids}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "revokedat", Value: time.Now()}}}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.revokeRefreshTokens(bson.D{{Key: "family", Value: bson.M{"$in": ids}}}); err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// @description Filter of sessions of user other than the session of the request. Requests without a session match all sessions.
+func otherSessionsFilter(userId primitive.ObjectID, r *http.Request) bson.D {
+	filter := bson.D{{Key: "user", Value: userId}}
+
+	if principal, _ := auth.PrincipalFrom(r); !principal.SessionID.IsZero() {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.M{"$ne": principal.SessionID}})
+	}
+
+	return filter
+}
@@ -3,14 +3,19 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"log"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/scheduler"
 	"github.com/bberkgulay/task-repetition-go/utils"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // @route       POST /api/v1/tasks
@@ -18,25 +23,46 @@ import (
 // @description Adds task for user.
 func (c Controller) AddTask() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var task models.Task
 		var error models.Error
+		//only editable fields are taken, scheduling fields are set by completing the task.
+		var request struct {
+			Title   string
+			Link    string
+			Summary string
+			Tags    []string
+		}
 
-		json.NewDecoder(r.Body).Decode(&task)
+		json.NewDecoder(r.Body).Decode(&request)
 
-		if task.Title == "" {
+		if request.Title == "" {
 			error.Message = "Enter missing fields. (Title)"
 			utils.SendError(w, http.StatusBadRequest, error)
 			return
 		}
 
-		//Getting user from header.
-		userId, hexError := primitive.ObjectIDFromHex(r.Header.Get("userID"))
+		//Getting user from request context.
+		userId, hexError := currentUserID(r)
 		if hexError != nil {
 			error.Message = "Error occurred getting user."
 			utils.SendError(w, http.StatusBadRequest, error)
 			return
 		}
-		task.User = userId
+
+		if !c.hasVerifiedEmail(userId) {
+			error.Message = "Verify your email before adding tasks."
+			utils.SendError(w, http.StatusForbidden, error)
+			return
+		}
+
+		task := models.Task{
+			Title:     request.Title,
+			Link:      request.Link,
+			Summary:   request.Summary,
+			Tags:      cleanTags(request.Tags),
+			User:      userId,
+			Version:   1,
+			UpdatedAt: time.Now(),
+		}
 
 		insertResult, err := c.DB.Collection("tasks").InsertOne(context.TODO(), task)
 
@@ -46,29 +72,36 @@ func (c Controller) AddTask() http.HandlerFunc {
 			return
 		}
 
+		w.Header().Set("ETag", utils.ETag(task.Version))
 		utils.SendSuccess(w, insertResult.InsertedID)
 	}
 }
 
 // @route       GET /api/v1/tasks
 // @access      Private
-// @description Returns tasks of user.
+// @description Returns the list of tasks of user, or a page with the total count when limit or cursor is given.
+// Filters, sort and cursor are described at parseTaskQuery.
 func (c Controller) GetTasks() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var error models.Error
 		var tasks []models.Task
 
-		//Getting user from header.
-		userId, hexError := primitive.ObjectIDFromHex(r.Header.Get("userID"))
+		//Getting user from request context.
+		userId, hexError := currentUserID(r)
 		if hexError != nil {
 			error.Message = "Error occurred about user."
 			utils.SendError(w, http.StatusBadRequest, error)
 			return
 		}
 
-		filter := bson.D{{Key: "user", Value: userId}}
+		query, err := parseTaskQuery(r, userId)
+		if err != nil {
+			error.Message = err.Error()
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
 
-		cursor, err := c.DB.Collection("tasks").Find(context.TODO(), filter)
+		cursor, err := c.DB.Collection("tasks").Find(context.TODO(), query.pageFilter(), query.findOptions())
 
 		if err != nil {
 			error.Message = "Server Error"
@@ -82,6 +115,106 @@ func (c Controller) GetTasks() http.HandlerFunc {
 			return
 		}
 
+		//one more task than the limit is read, it means there is a next page.
+		var nextCursor string
+		if query.paged && int64(len(tasks)) > query.limit {
+			tasks = tasks[:query.limit]
+			if nextCursor, err = query.nextCursor(tasks[query.limit-1]); err != nil {
+				error.Message = "Server Error"
+				utils.SendError(w, http.StatusInternalServerError, error)
+				return
+			}
+		}
+
+		if r.URL.Query().Get("retrievability") == "true" {
+			if err = c.setRetrievability(userId, tasks); err != nil {
+				error.Message = "Server Error"
+				utils.SendError(w, http.StatusInternalServerError, error)
+				return
+			}
+		}
+
+		if !query.paged {
+			utils.SendWithETag(w, r, "", tasks)
+			return
+		}
+
+		total, err := c.DB.Collection("tasks").CountDocuments(context.TODO(), query.filter)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		page := models.TaskPage{Tasks: tasks, Total: total, NextCursor: nextCursor}
+
+		utils.SendWithETag(w, r, "", page)
+	}
+}
+
+// @route       GET /api/v1/tasks/due
+// @access      Private
+// @description Returns tasks of user whose repetition day has come. Query sort=retrievability orders lowest recall probability first, default order is by repetition day.
+func (c Controller) GetDueTasks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var error models.Error
+		var tasks []models.Task
+
+		sortMode := r.URL.Query().Get("sort")
+		if sortMode != "" && sortMode != "due" && sortMode != "retrievability" {
+			error.Message = "Incorrect sort value. (due, retrievability)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		//Getting user from request context.
+		userId, hexError := currentUserID(r)
+		if hexError != nil {
+			error.Message = "Error occurred about user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{
+			{Key: "user", Value: userId},
+			{Key: "completedday", Value: bson.M{"$exists": false}},
+			{Key: "repetitionbeginday", Value: bson.M{"$lte": time.Now()}},
+		}
+
+		queryOptions := options.FindOptions{}
+		queryOptions.SetSort(bson.D{{Key: "repetitionbeginday", Value: 1}})
+
+		cursor, err := c.DB.Collection("tasks").Find(context.TODO(), filter, &queryOptions)
+
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if err = cursor.All(context.TODO(), &tasks); err != nil {
+			error.Message = "Error while parsing data."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if sortMode == "retrievability" || r.URL.Query().Get("retrievability") == "true" {
+			if err = c.setRetrievability(userId, tasks); err != nil {
+				error.Message = "Server Error"
+				utils.SendError(w, http.StatusInternalServerError, error)
+				return
+			}
+		}
+
+		if sortMode == "retrievability" {
+			sort.SliceStable(tasks, func(i, j int) bool {
+				if tasks[i].Retrievability == nil || tasks[j].Retrievability == nil {
+					return tasks[j].Retrievability == nil && tasks[i].Retrievability != nil
+				}
+				return *tasks[i].Retrievability < *tasks[j].Retrievability
+			})
+		}
+
 		utils.SendSuccess(w, tasks)
 	}
 }
@@ -104,8 +237,8 @@ func (c Controller) GetTask() http.HandlerFunc {
 			return
 		}
 
-		//Getting user from header for owner control.
-		userId, err := primitive.ObjectIDFromHex(r.Header.Get("userID"))
+		//Getting user from request context for owner control.
+		userId, err := currentUserID(r)
 		if err != nil {
 			error.Message = "Error while getting user."
 			utils.SendError(w, http.StatusBadRequest, error)
@@ -121,13 +254,27 @@ func (c Controller) GetTask() http.HandlerFunc {
 			return
 		}
 
-		utils.SendSuccess(w, task)
+		//retrievability changes with time, the response is not cached then but the ETag can still be used for changes.
+		if r.URL.Query().Get("retrievability") == "true" {
+			tasks := []models.Task{task}
+			if err = c.setRetrievability(userId, tasks); err != nil {
+				error.Message = "Server Error"
+				utils.SendError(w, http.StatusInternalServerError, error)
+				return
+			}
+			w.Header().Set("ETag", utils.ETag(task.Version))
+			utils.SendSuccess(w, tasks[0])
+			return
+		}
+
+		utils.SendWithETag(w, r, utils.ETag(task.Version), task)
 	}
 }
 
 // @route       PUT /api/v1/tasks/{id}
 // @access      Private
-// @description Updates task by id with owner control.
+// @description Updates title, link, summary and tags of task by id with owner control. Empty fields and the other
+// fields of the task are kept.
 func (c Controller) UpdateTask() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var task models.Task
@@ -150,8 +297,8 @@ func (c Controller) UpdateTask() http.HandlerFunc {
 			return
 		}
 
-		//Getting user from header for owner control.
-		userId, err := primitive.ObjectIDFromHex(r.Header.Get("userID"))
+		//Getting user from request context for owner control.
+		userId, err := currentUserID(r)
 		if err != nil {
 			error.Message = "Error while getting user."
 			utils.SendError(w, http.StatusBadRequest, error)
@@ -160,13 +307,7 @@ func (c Controller) UpdateTask() http.HandlerFunc {
 
 		filter := bson.D{{Key: "_id", Value: objectId}, {Key: "user", Value: userId}}
 
-		result, err := c.DB.Collection("tasks").UpdateOne(
-			context.TODO(),
-			filter,
-			bson.D{
-				{"$set", task},
-			},
-		)
+		result, err := c.DB.Collection("tasks").UpdateOne(context.TODO(), withIfMatch(r, filter), taskUpdate(task).pipeline())
 
 		if err != nil {
 			error.Message = "Server error"
@@ -174,10 +315,75 @@ func (c Controller) UpdateTask() http.HandlerFunc {
 			return
 		}
 
+		if result.MatchedCount == 0 {
+			c.sendUnmatched(w, "tasks", filter, http.StatusNotFound, "No task with this ID.")
+			return
+		}
+
 		utils.SendSuccess(w, result)
 	}
 }
 
+// @route       PATCH /api/v1/tasks/{id}
+// @access      Private
+// @description Changes fields of task by id with owner control and returns the updated task. Body is
+// {"Set": {"Title": "..."}, "Clear": ["Summary"], "AddTags": ["..."], "RemoveTags": ["..."]}, every part is optional.
+// Only title, link, summary and tags can be changed, title can not be cleared.
+func (c Controller) PatchTask() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var patch taskPatch
+		var task models.Task
+		var error models.Error
+
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			error.Message = "Incorrect request body."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		change, err := patch.change()
+		if err != nil {
+			error.Message = err.Error()
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		params := mux.Vars(r)
+
+		objectId, err := primitive.ObjectIDFromHex(params["id"])
+		if err != nil {
+			error.Message = "Incorrect ID value."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		//Getting user from request context for owner control.
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "_id", Value: objectId}, {Key: "user", Value: userId}}
+		queryOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		err = c.DB.Collection("tasks").FindOneAndUpdate(context.TODO(), withIfMatch(r, filter), change.pipeline(), queryOptions).Decode(&task)
+		if err == mongo.ErrNoDocuments {
+			c.sendUnmatched(w, "tasks", filter, http.StatusNotFound, "No task with this ID.")
+			return
+		}
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		w.Header().Set("ETag", utils.ETag(task.Version))
+		utils.SendSuccess(w, task)
+	}
+}
+
 // @route       DELETE /api/v1/tasks/{id}
 // @access      Private
 // @description Deletes task by id with owner control.
@@ -193,8 +399,8 @@ func (c Controller) DeleteTask() http.HandlerFunc {
 			return
 		}
 
-		//Getting user from header for owner control.
-		userId, err := primitive.ObjectIDFromHex(r.Header.Get("userID"))
+		//Getting user from request context for owner control.
+		userId, err := currentUserID(r)
 		if err != nil {
 			error.Message = "Error while getting user."
 			utils.SendError(w, http.StatusBadRequest, error)
@@ -203,11 +409,23 @@ func (c Controller) DeleteTask() http.HandlerFunc {
 
 		filter := bson.D{{Key: "_id", Value: objectId}, {Key: "user", Value: userId}}
 
-		result := c.DB.Collection("tasks").FindOneAndDelete(context.TODO(), filter).Err()
+		result := c.DB.Collection("tasks").FindOneAndDelete(context.TODO(), withIfMatch(r, filter)).Err()
 
+		if result == mongo.ErrNoDocuments {
+			c.sendUnmatched(w, "tasks", filter, http.StatusBadRequest, "No task to delete")
+			return
+		}
 		if result != nil {
-			error.Message = "No task to delete"
-			utils.SendError(w, http.StatusBadRequest, error)
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		//notes of task are deleted with it.
+		_, err = c.DB.Collection("notes").DeleteMany(context.TODO(), bson.D{{Key: "task", Value: objectId}, {Key: "user", Value: userId}})
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
 			return
 		}
 
@@ -218,11 +436,18 @@ func (c Controller) DeleteTask() http.HandlerFunc {
 // @route       PUT /api/v1/tasks/{id}/complete
 // @access      Private
 // @description Completes task and finds suitable repetition type and repetition date.
+// Optional body {"recalled": false} logs a failed recall and restarts the repetition ladder.
 func (c Controller) CompleteTask() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		var task models.Task
 		var error models.Error
+		var completion struct {
+			Recalled *bool
+		}
+
+		json.NewDecoder(r.Body).Decode(&completion)
+		recalled := completion.Recalled == nil || *completion.Recalled
 
 		params := mux.Vars(r)
 
@@ -233,14 +458,14 @@ func (c Controller) CompleteTask() http.HandlerFunc {
 			return
 		}
 
-		userId, err := primitive.ObjectIDFromHex(r.Header.Get("userID"))
+		userId, err := currentUserID(r)
 		if err != nil {
 			error.Message = "Error while getting user."
 			utils.SendError(w, http.StatusBadRequest, error)
 			return
 		}
 
-		filter := bson.D{{"_id", objectId}, {Key: "user", Value: userId}}
+		filter := bson.D{{Key: "_id", Value: objectId}, {Key: "user", Value: userId}}
 		findError := c.DB.Collection("tasks").FindOne(context.TODO(), filter).Decode(&task)
 
 		if findError != nil {
@@ -255,6 +480,12 @@ func (c Controller) CompleteTask() http.HandlerFunc {
 			return
 		}
 
+		if !ifMatches(r, task.Version) {
+			error.Message = "It was changed in the meantime, get it again."
+			utils.SendError(w, http.StatusPreconditionFailed, error)
+			return
+		}
+
 		//TODO begin day control
 		// if task.RepetitionBeginDay > time.Now() {
 		// 	error.Message = ""
@@ -262,7 +493,29 @@ func (c Controller) CompleteTask() http.HandlerFunc {
 		// 	return
 		// }
 
-		nextRepetitionType, err := c.GetNextRepetitionType(task.RepetitionType)
+		now := time.Now()
+
+		//a task with repetition type is being reviewed, the outcome is logged for the scheduler optimizer once the
+		//task is changed, so that a completion losing a race is not logged.
+		var review *models.Review
+		if !task.RepetitionType.IsZero() && !task.LastReviewDay.IsZero() {
+			review = &models.Review{
+				User:           userId,
+				Task:           task.ID,
+				RepetitionType: task.RepetitionType,
+				Interval:       now.Sub(task.LastReviewDay).Hours() / 24,
+				Recalled:       recalled,
+				ReviewedAt:     now,
+			}
+		}
+
+		currentRepetitionType := task.RepetitionType
+		//forgotten task starts from the first step of the ladder again.
+		if !recalled {
+			currentRepetitionType = primitive.NilObjectID
+		}
+
+		nextRepetitionType, err := c.GetNextRepetitionType(userId, currentRepetitionType)
 		if err != nil {
 			error.Message = "Error"
 			utils.SendError(w, http.StatusBadRequest, error)
@@ -270,26 +523,30 @@ func (c Controller) CompleteTask() http.HandlerFunc {
 		}
 
 		message := "Successful"
+		task.LastReviewDay = now
 
 		//no next repetition day means the user has completed the task.
 		if nextRepetitionType == nil {
-			task.CompletedDay = time.Now()
+			task.CompletedDay = now
 			message = "Task is completed successfully."
 		} else {
 			//Sets next repetition begin date and type.
-			task.RepetitionBeginDay = time.Now().AddDate(0, 0, nextRepetitionType.Day)
+			task.RepetitionBeginDay = now.AddDate(0, 0, nextRepetitionType.Day)
 			task.RepetitionType = nextRepetitionType.ID
 			message = "Successful"
 		}
 
-		filter = bson.D{{Key: "_id", Value: objectId}, {Key: "user", Value: userId}}
+		//the task is only changed if it is still the version which is read.
+		filter = bson.D{{Key: "_id", Value: objectId}, {Key: "user", Value: userId}, versionIn(task.Version)}
+		task.Version++
+		task.UpdatedAt = now
+
 		result, err := c.DB.Collection("tasks").UpdateOne(
 			context.TODO(),
 			filter,
 			bson.D{
-				{"$set", task},
+				{Key: "$set", Value: task},
 			})
-		_ = result
 
 		if err != nil {
 			error.Message = "Server error"
@@ -297,6 +554,70 @@ func (c Controller) CompleteTask() http.HandlerFunc {
 			return
 		}
 
+		if result.MatchedCount == 0 {
+			error.Message = "Task is changed at the same time, try again."
+			utils.SendError(w, http.StatusConflict, error)
+			return
+		}
+
+		//the task is completed already, a retry would complete it again, so a lost review is only logged.
+		if review != nil {
+			if _, err := c.DB.Collection("reviews").InsertOne(context.TODO(), review); err != nil {
+				log.Println("Error while logging review of task", task.ID.Hex(), err)
+			}
+		}
+
+		w.Header().Set("ETag", utils.ETag(task.Version))
 		utils.SendSuccess(w, message)
 	}
 }
+
+// @description Sets estimated probability of recall of tasks right now. It is derived from the elapsed time since the last review
+// and the interval of its repetition type, which is assumed to be tuned for the target retention.
+func (c Controller) setRetrievability(userId primitive.ObjectID, tasks []models.Task) error {
+	var repetitionTypes []models.RepetitionType
+
+	filter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "user", Value: userId}},
+		bson.D{{Key: "user", Value: bson.M{"$exists": false}}},
+	}}}
+
+	cursor, err := c.DB.Collection("repetitiontypes").Find(context.TODO(), filter)
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(context.TODO(), &repetitionTypes); err != nil {
+		return err
+	}
+
+	days := map[primitive.ObjectID]int{}
+	for _, repetitionType := range repetitionTypes {
+		days[repetitionType.ID] = repetitionType.Day
+	}
+
+	setRetrievabilityAt(tasks, days, time.Now())
+
+	return nil
+}
+
+// @description Sets probability of recall of tasks at now, days are the intervals of repetition types by their ID.
+func setRetrievabilityAt(tasks []models.Task, days map[primitive.ObjectID]int, now time.Time) {
+	for i := range tasks {
+		task := &tasks[i]
+
+		day, ok := days[task.RepetitionType]
+		//tasks not studied yet or already completed have no repetition to recall.
+		if !ok || day <= 0 || !task.CompletedDay.IsZero() {
+			continue
+		}
+
+		lastReviewDay := task.LastReviewDay
+		if lastReviewDay.IsZero() {
+			lastReviewDay = task.RepetitionBeginDay.AddDate(0, 0, -day)
+		}
+
+		stability := scheduler.StabilityForInterval(float64(day), scheduler.DefaultTargetRetention)
+		retrievability := scheduler.Retention(now.Sub(lastReviewDay).Hours()/24, stability)
+		task.Retrievability = &retrievability
+	}
+}
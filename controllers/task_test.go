@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/scheduler"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSetRetrievability(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tenDays := primitive.NewObjectID()
+	noInterval := primitive.NewObjectID()
+	days := map[primitive.ObjectID]int{tenDays: 10, noInterval: 0}
+
+	tests := []struct {
+		name string
+		task models.Task
+		// want is nil for tasks without a repetition to recall.
+		want *float64
+	}{
+		{"reviewed now", models.Task{RepetitionType: tenDays, LastReviewDay: now, RepetitionBeginDay: now.AddDate(0, 0, 10)}, probability(1)},
+		{"due now", models.Task{RepetitionType: tenDays, LastReviewDay: now.AddDate(0, 0, -10), RepetitionBeginDay: now}, probability(scheduler.DefaultTargetRetention)},
+		//tasks reviewed before reviews were logged are assumed to be reviewed one interval before they are due.
+		{"zero last review day", models.Task{RepetitionType: tenDays, RepetitionBeginDay: now}, probability(scheduler.DefaultTargetRetention)},
+		{"overdue", models.Task{RepetitionType: tenDays, LastReviewDay: now.AddDate(0, 0, -30), RepetitionBeginDay: now.AddDate(0, 0, -20)}, probability(math.Pow(scheduler.DefaultTargetRetention, 3))},
+		{"missing repetition type", models.Task{LastReviewDay: now.AddDate(0, 0, -1)}, nil},
+		{"unknown repetition type", models.Task{RepetitionType: primitive.NewObjectID(), LastReviewDay: now.AddDate(0, 0, -1)}, nil},
+		{"repetition type without interval", models.Task{RepetitionType: noInterval, LastReviewDay: now.AddDate(0, 0, -1)}, nil},
+		{"completed", models.Task{RepetitionType: tenDays, LastReviewDay: now.AddDate(0, 0, -1), CompletedDay: now}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tasks := []models.Task{test.task}
+			setRetrievabilityAt(tasks, days, now)
+
+			got := tasks[0].Retrievability
+			if (got == nil) != (test.want == nil) {
+				t.Fatalf("Retrievability = %v, want %v", got, test.want)
+			}
+			if got != nil && math.Abs(*got-*test.want) > 1e-9 {
+				t.Errorf("Retrievability = %f, want %f", *got, *test.want)
+			}
+		})
+	}
+}
+
+func probability(p float64) *float64 {
+	return &p
+}
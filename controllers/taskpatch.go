@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/bberkgulay/task-repetition-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fields of tasks which users may change, by their lowercase name in requests. Scheduling fields are only changed by
+// completing the task.
+var editableTaskFields = map[string]string{
+	"title":   "title",
+	"link":    "link",
+	"summary": "summary",
+	"tags":    "tags",
+}
+
+// taskPatch is the body of PATCH /tasks/{id}. Set changes fields to the given values, Clear removes fields,
+// AddTags and RemoveTags change tags keeping the others.
+type taskPatch struct {
+	Set        map[string]json.RawMessage
+	Clear      []string
+	AddTags    []string
+	RemoveTags []string
+}
+
+// taskChange is a validated change of the editable fields of a task.
+type taskChange struct {
+	set        bson.D
+	unset      []string
+	addTags    []string
+	removeTags []string
+}
+
+// @description Validates patch against the editable fields and returns the change it makes.
+func (p taskPatch) change() (taskChange, error) {
+	var change taskChange
+	changed := map[string]bool{}
+
+	names := make([]string, 0, len(p.Set))
+	for name := range p.Set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field, ok := editableTaskFields[strings.ToLower(name)]
+		if !ok {
+			return change, errors.New("Field " + name + " can not be changed.")
+		}
+		if changed[field] {
+			return change, errors.New("Field " + name + " is given more than once.")
+		}
+		changed[field] = true
+
+		if field == "tags" {
+			var tags []string
+			if err := json.Unmarshal(p.Set[name], &tags); err != nil {
+				return change, errors.New("Field " + name + " must be a list of strings.")
+			}
+			change.put(field, cleanTags(tags))
+			continue
+		}
+
+		var value string
+		if err := json.Unmarshal(p.Set[name], &value); err != nil {
+			return change, errors.New("Field " + name + " must be a string.")
+		}
+		if field == "title" && strings.TrimSpace(value) == "" {
+			return change, errors.New("Title can not be empty.")
+		}
+		change.put(field, value)
+	}
+
+	for _, name := range p.Clear {
+		field, ok := editableTaskFields[strings.ToLower(name)]
+		if !ok || field == "title" {
+			return change, errors.New("Field " + name + " can not be cleared.")
+		}
+		if changed[field] {
+			return change, errors.New("Field " + name + " is given more than once.")
+		}
+		changed[field] = true
+		change.unset = append(change.unset, field)
+	}
+
+	change.addTags = cleanTags(p.AddTags)
+	change.removeTags = cleanTags(p.RemoveTags)
+	if len(change.addTags)+len(change.removeTags) > 0 && changed["tags"] {
+		return change, errors.New("Tags can not be set or cleared and changed with AddTags or RemoveTags at once.")
+	}
+	for _, tag := range change.addTags {
+		for _, removed := range change.removeTags {
+			if tag == removed {
+				return change, errors.New("Tag " + tag + " is both added and removed.")
+			}
+		}
+	}
+
+	if len(change.set)+len(change.unset)+len(change.addTags)+len(change.removeTags) == 0 {
+		return change, errors.New("Nothing to change. (Set, Clear, AddTags, RemoveTags)")
+	}
+
+	return change, nil
+}
+
+// @description Returns the change which sets the editable fields given in task. Empty fields are kept as they are,
+// they are cleared with PATCH.
+func taskUpdate(task models.Task) taskChange {
+	var change taskChange
+
+	if task.Title != "" {
+		change.put("title", task.Title)
+	}
+	if task.Link != "" {
+		change.put("link", task.Link)
+	}
+	if task.Summary != "" {
+		change.put("summary", task.Summary)
+	}
+	if tags := cleanTags(task.Tags); len(tags) > 0 {
+		change.put("tags", tags)
+	}
+
+	return change
+}
+
+// put sets the field, empty values remove it because empty fields are not stored.
+func (c *taskChange) put(field string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			c.unset = append(c.unset, field)
+			return
+		}
+	case []string:
+		if len(v) == 0 {
+			c.unset = append(c.unset, field)
+			return
+		}
+	}
+
+	//values are literals so that strings starting with $ are not read as field paths by the pipeline.
+	c.set = append(c.set, bson.E{Key: field, Value: bson.D{{Key: "$literal", Value: value}}})
+}
+
+// @description Returns the update pipeline of the change, which is a new version of the task. Tags are added and removed
+// in one update, keeping their order.
+func (c taskChange) pipeline() mongo.Pipeline {
+	var pipeline mongo.Pipeline
+
+	if len(c.set) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$set", Value: c.set}})
+	}
+	if len(c.unset) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$unset", Value: c.unset}})
+	}
+
+	if len(c.addTags)+len(c.removeTags) > 0 {
+		current := bson.D{{Key: "$ifNull", Value: bson.A{"$tags", bson.A{}}}}
+		notIn := func(list interface{}) bson.D {
+			return bson.D{{Key: "$not", Value: bson.A{bson.D{{Key: "$in", Value: bson.A{"$$this", list}}}}}}
+		}
+		literal := func(tags []string) bson.D {
+			if tags == nil {
+				tags = []string{}
+			}
+			return bson.D{{Key: "$literal", Value: tags}}
+		}
+
+		tags := bson.D{{Key: "$concatArrays", Value: bson.A{
+			bson.D{{Key: "$filter", Value: bson.D{{Key: "input", Value: current}, {Key: "cond", Value: notIn(literal(c.removeTags))}}}},
+			bson.D{{Key: "$filter", Value: bson.D{{Key: "input", Value: literal(c.addTags)}, {Key: "cond", Value: notIn(current)}}}},
+		}}}
+
+		pipeline = append(pipeline,
+			bson.D{{Key: "$set", Value: bson.D{{Key: "tags", Value: tags}}}},
+			//tags are removed when the last one is removed.
+			bson.D{{Key: "$set", Value: bson.D{{Key: "tags", Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$size", Value: "$tags"}}, 0}}},
+				"$$REMOVE",
+				"$tags",
+			}}}}}}},
+		)
+	}
+
+	return append(pipeline, newVersionStage())
+}
+
+// @description Trims tags and drops empty and repeated ones.
+func cleanTags(tags []string) []string {
+	var cleaned []string
+	seen := map[string]bool{}
+
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		cleaned = append(cleaned, tag)
+	}
+
+	return cleaned
+}
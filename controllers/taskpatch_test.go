@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// literalField is how taskChange.put sets field to value.
+func literalField(field string, value interface{}) bson.E {
+	return bson.E{Key: field, Value: bson.D{{Key: "$literal", Value: value}}}
+}
+
+func TestTaskPatchChange(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want taskChange
+		ok   bool
+	}{
+		{"set fields in any case", `{"Set": {"title": "Go", "Summary": "Tour"}}`, taskChange{set: bson.D{literalField("summary", "Tour"), literalField("title", "Go")}}, true},
+		{"set tags", `{"Set": {"Tags": [" go ", "go", ""]}}`, taskChange{set: bson.D{literalField("tags", []string{"go"})}}, true},
+		{"set empty value", `{"Set": {"Link": ""}}`, taskChange{unset: []string{"link"}}, true},
+		{"clear", `{"Clear": ["Summary", "Link"]}`, taskChange{unset: []string{"summary", "link"}}, true},
+		{"add and remove tags", `{"AddTags": ["go", " "], "RemoveTags": ["old"]}`, taskChange{addTags: []string{"go"}, removeTags: []string{"old"}}, true},
+		{"scheduling field", `{"Set": {"LastReviewDay": "2024-03-01T00:00:00Z"}}`, taskChange{}, false},
+		{"version", `{"Set": {"Version": 7}}`, taskChange{}, false},
+		{"empty title", `{"Set": {"Title": "  "}}`, taskChange{}, false},
+		{"clear title", `{"Clear": ["Title"]}`, taskChange{}, false},
+		{"title not a string", `{"Set": {"Title": 1}}`, taskChange{}, false},
+		{"tags not a list", `{"Set": {"Tags": "go"}}`, taskChange{}, false},
+		{"field given twice", `{"Set": {"title": "a", "Title": "b"}}`, taskChange{}, false},
+		{"field set and cleared", `{"Set": {"Summary": "a"}, "Clear": ["summary"]}`, taskChange{}, false},
+		{"tags set and added", `{"Set": {"Tags": ["a"]}, "AddTags": ["b"]}`, taskChange{}, false},
+		{"tag added and removed", `{"AddTags": ["a"], "RemoveTags": ["a"]}`, taskChange{}, false},
+		{"nothing", `{}`, taskChange{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var patch taskPatch
+			if err := json.Unmarshal([]byte(test.body), &patch); err != nil {
+				t.Fatal(err)
+			}
+
+			change, err := patch.change()
+			if (err == nil) != test.ok {
+				t.Fatalf("change() error = %v, want ok %v", err, test.ok)
+			}
+			if test.ok && !reflect.DeepEqual(change, test.want) {
+				t.Errorf("change() = %+v, want %+v", change, test.want)
+			}
+		})
+	}
+}
+
+func TestTaskUpdate(t *testing.T) {
+	tests := []struct {
+		name string
+		task models.Task
+		want taskChange
+	}{
+		{
+			"all fields",
+			models.Task{Title: "Go", Link: "https://go.dev", Summary: "Tour", Tags: []string{"go"}},
+			taskChange{set: bson.D{literalField("title", "Go"), literalField("link", "https://go.dev"), literalField("summary", "Tour"), literalField("tags", []string{"go"})}},
+		},
+		//omitted fields are kept, they are cleared with PATCH.
+		{"omitted fields are kept", models.Task{Title: "Go"}, taskChange{set: bson.D{literalField("title", "Go")}}},
+		{"empty tags are kept", models.Task{Title: "Go", Tags: []string{" ", ""}}, taskChange{set: bson.D{literalField("title", "Go")}}},
+		{"scheduling fields are ignored", models.Task{Title: "Go", Version: 7, CompletedDay: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}, taskChange{set: bson.D{literalField("title", "Go")}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if change := taskUpdate(test.task); !reflect.DeepEqual(change, test.want) {
+				t.Errorf("taskUpdate() = %+v, want %+v", change, test.want)
+			}
+		})
+	}
+}
+
+func TestTaskChangePipeline(t *testing.T) {
+	tests := []struct {
+		name   string
+		change taskChange
+		// stages are the operators of the stages before the new version.
+		stages []string
+	}{
+		{"set", taskChange{set: bson.D{literalField("title", "Go")}}, []string{"$set"}},
+		{"set and unset", taskChange{set: bson.D{literalField("title", "Go")}, unset: []string{"link"}}, []string{"$set", "$unset"}},
+		{"tags", taskChange{addTags: []string{"go"}}, []string{"$set", "$set"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pipeline := test.change.pipeline()
+			if len(pipeline) != len(test.stages)+1 {
+				t.Fatalf("pipeline() has %d stages, want %d", len(pipeline), len(test.stages)+1)
+			}
+			for i, operator := range test.stages {
+				if pipeline[i][0].Key != operator {
+					t.Errorf("pipeline() stage %d = %s, want %s", i, pipeline[i][0].Key, operator)
+				}
+			}
+
+			version := pipeline[len(pipeline)-1][0].Value.(bson.D)
+			if version[0].Key != "version" || version[1].Key != "updatedat" {
+				t.Errorf("pipeline() does not end with a new version: %v", version)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sortable fields of tasks by the name used in the query.
+var taskSortFields = map[string]string{
+	"created": "_id",
+	"title":   "title",
+	"due":     "repetitionbeginday",
+}
+
+// taskQuery is the parsed query of GET /tasks.
+type taskQuery struct {
+	filter    bson.D
+	sortField string
+	ascending bool
+	// paged is set by limit or cursor, otherwise all tasks are returned as a list like before pagination.
+	paged  bool
+	limit  int64
+	cursor *taskCursor
+}
+
+// taskCursor points after the last task of a page. Sort is checked so that a cursor is only used with the sort it is made for.
+type taskCursor struct {
+	Sort  string             `bson:"s"`
+	Value interface{}        `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+// @description Parses filters, sort and page of GET /tasks for tasks of user.
+// Query parameters: tags (comma separated), tagMode (any, all), status (active, completed),
+// dueBefore, dueAfter (RFC 3339 or YYYY-MM-DD), repetitionType (id), title (text contained),
+// sort (created, title, due), order (asc, desc), limit (default 50 when paged), cursor. A page is only returned when
+// limit or cursor is given.
+func parseTaskQuery(r *http.Request, userId primitive.ObjectID) (taskQuery, error) {
+	query := r.URL.Query()
+	result := taskQuery{filter: bson.D{{Key: "user", Value: userId}}}
+
+	if tags := splitList(query.Get("tags")); len(tags) > 0 {
+		switch query.Get("tagMode") {
+		case "", "any":
+			result.filter = append(result.filter, bson.E{Key: "tags", Value: bson.M{"$in": tags}})
+		case "all":
+			result.filter = append(result.filter, bson.E{Key: "tags", Value: bson.M{"$all": tags}})
+		default:
+			return result, errors.New("Incorrect tagMode value. (any, all)")
+		}
+	}
+
+	switch query.Get("status") {
+	case "":
+	case "active":
+		result.filter = append(result.filter, bson.E{Key: "completedday", Value: bson.M{"$exists": false}})
+	case "completed":
+		result.filter = append(result.filter, bson.E{Key: "completedday", Value: bson.M{"$exists": true}})
+	default:
+		return result, errors.New("Incorrect status value. (active, completed)")
+	}
+
+	due := bson.M{}
+	for parameter, operator := range map[string]string{"dueBefore": "$lt", "dueAfter": "$gte"} {
+		if query.Get(parameter) == "" {
+			continue
+		}
+		value, err := parseQueryTime(query.Get(parameter))
+		if err != nil {
+			return result, errors.New("Incorrect " + parameter + " value, use RFC 3339 or YYYY-MM-DD.")
+		}
+		due[operator] = value
+	}
+	if len(due) > 0 {
+		result.filter = append(result.filter, bson.E{Key: "repetitionbeginday", Value: due})
+	}
+
+	if query.Get("repetitionType") != "" {
+		repetitionType, err := primitive.ObjectIDFromHex(query.Get("repetitionType"))
+		if err != nil {
+			return result, errors.New("Incorrect repetitionType value.")
+		}
+		result.filter = append(result.filter, bson.E{Key: "repetitiontype", Value: repetitionType})
+	}
+
+	if title := strings.TrimSpace(query.Get("title")); title != "" {
+		result.filter = append(result.filter, bson.E{Key: "title", Value: primitive.Regex{Pattern: regexp.QuoteMeta(title), Options: "i"}})
+	}
+
+	sortName := query.Get("sort")
+	if sortName == "" {
+		sortName = "created"
+	}
+	sortField, ok := taskSortFields[sortName]
+	if !ok {
+		return result, errors.New("Incorrect sort value. (created, title, due)")
+	}
+	result.sortField = sortField
+
+	switch query.Get("order") {
+	case "", "asc":
+		result.ascending = true
+	case "desc":
+	default:
+		return result, errors.New("Incorrect order value. (asc, desc)")
+	}
+
+	result.paged = query.Get("limit") != "" || query.Get("cursor") != ""
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	result.limit = int64(limit)
+
+	if query.Get("cursor") != "" {
+		cursor, err := decodeTaskCursor(query.Get("cursor"))
+		if err != nil || cursor.Sort != result.sortKey() {
+			return result, errors.New("Incorrect cursor value, it does not belong to this sort.")
+		}
+		result.cursor = &cursor
+	}
+
+	return result, nil
+}
+
+// sortKey identifies the sort of the query in its cursors.
+func (q taskQuery) sortKey() string {
+	if q.ascending {
+		return q.sortField
+	}
+	return "-" + q.sortField
+}
+
+// findOptions sorts by the sort field with id as tie breaker. Paged queries read one task more than the limit to know whether there is a next page.
+func (q taskQuery) findOptions() *options.FindOptions {
+	direction := -1
+	if q.ascending {
+		direction = 1
+	}
+
+	sort := bson.D{{Key: "_id", Value: direction}}
+	if q.sortField != "_id" {
+		sort = append(bson.D{{Key: q.sortField, Value: direction}}, sort...)
+	}
+
+	if !q.paged {
+		return options.Find().SetSort(sort)
+	}
+	return options.Find().SetSort(sort).SetLimit(q.limit + 1)
+}
+
+// pageFilter is the filter of the query for the page after the cursor.
+func (q taskQuery) pageFilter() bson.D {
+	if q.cursor == nil {
+		return q.filter
+	}
+
+	after := "$gt"
+	if !q.ascending {
+		after = "$lt"
+	}
+
+	if q.sortField == "_id" {
+		return append(q.filter, bson.E{Key: "_id", Value: bson.M{after: q.cursor.ID}})
+	}
+
+	//missing values sort before all others, so they come first in ascending and last in descending order.
+	var next bson.A
+	if q.cursor.Value == nil {
+		next = bson.A{bson.D{{Key: q.sortField, Value: nil}, {Key: "_id", Value: bson.M{after: q.cursor.ID}}}}
+		if q.ascending {
+			next = append(next, bson.D{{Key: q.sortField, Value: bson.M{"$ne": nil}}})
+		}
+	} else {
+		next = bson.A{
+			bson.D{{Key: q.sortField, Value: bson.M{after: q.cursor.Value}}},
+			bson.D{{Key: q.sortField, Value: q.cursor.Value}, {Key: "_id", Value: bson.M{after: q.cursor.ID}}},
+		}
+		if !q.ascending {
+			next = append(next, bson.D{{Key: q.sortField, Value: nil}})
+		}
+	}
+
+	return append(q.filter, bson.E{Key: "$or", Value: next})
+}
+
+// nextCursor returns the cursor after the last task of the page.
+func (q taskQuery) nextCursor(last models.Task) (string, error) {
+	cursor := taskCursor{Sort: q.sortKey(), ID: last.ID}
+
+	//empty values are not stored, they are missing in the document.
+	switch q.sortField {
+	case "title":
+		if last.Title != "" {
+			cursor.Value = last.Title
+		}
+	case "repetitionbeginday":
+		if !last.RepetitionBeginDay.IsZero() {
+			cursor.Value = last.RepetitionBeginDay
+		}
+	}
+
+	bytes, err := bson.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func decodeTaskCursor(value string) (taskCursor, error) {
+	var cursor taskCursor
+
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+
+	err = bson.Unmarshal(bytes, &cursor)
+
+	return cursor, err
+}
+
+// @description Splits a comma separated query value, empty items are dropped.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// @description Parses a time of a query parameter in RFC 3339 or as a date.
+func parseQueryTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
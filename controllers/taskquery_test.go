@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseTaskQuery(t *testing.T) {
+	userId := primitive.NewObjectID()
+	repetitionType := primitive.NewObjectID()
+	before := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	after := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		query string
+		// filter is the filter after the user.
+		filter  bson.D
+		sortKey string
+		paged   bool
+		limit   int64
+		ok      bool
+	}{
+		{name: "defaults", sortKey: "_id", limit: 50, ok: true},
+		{name: "any tag", query: "tags=go,,%20db%20", filter: bson.D{{Key: "tags", Value: bson.M{"$in": []string{"go", "db"}}}}, sortKey: "_id", limit: 50, ok: true},
+		{name: "all tags", query: "tags=go,db&tagMode=all", filter: bson.D{{Key: "tags", Value: bson.M{"$all": []string{"go", "db"}}}}, sortKey: "_id", limit: 50, ok: true},
+		{name: "unknown tag mode", query: "tags=go&tagMode=some"},
+		{name: "active", query: "status=active", filter: bson.D{{Key: "completedday", Value: bson.M{"$exists": false}}}, sortKey: "_id", limit: 50, ok: true},
+		{name: "completed", query: "status=completed", filter: bson.D{{Key: "completedday", Value: bson.M{"$exists": true}}}, sortKey: "_id", limit: 50, ok: true},
+		{name: "unknown status", query: "status=suspended"},
+		{
+			name:    "due between",
+			query:   "dueBefore=2024-03-02&dueAfter=2024-03-01T12:00:00Z",
+			filter:  bson.D{{Key: "repetitionbeginday", Value: bson.M{"$lt": before, "$gte": after}}},
+			sortKey: "_id",
+			limit:   50,
+			ok:      true,
+		},
+		{name: "incorrect due", query: "dueBefore=tomorrow"},
+		{name: "repetition type", query: "repetitionType=" + repetitionType.Hex(), filter: bson.D{{Key: "repetitiontype", Value: repetitionType}}, sortKey: "_id", limit: 50, ok: true},
+		{name: "incorrect repetition type", query: "repetitionType=first"},
+		{name: "title is quoted", query: "title=a.b", filter: bson.D{{Key: "title", Value: primitive.Regex{Pattern: `a\.b`, Options: "i"}}}, sortKey: "_id", limit: 50, ok: true},
+		{name: "sort by due descending", query: "sort=due&order=desc", sortKey: "-repetitionbeginday", limit: 50, ok: true},
+		{name: "unknown sort", query: "sort=priority"},
+		{name: "unknown order", query: "order=up"},
+		{name: "limit", query: "limit=10", sortKey: "_id", paged: true, limit: 10, ok: true},
+		{name: "limit over maximum", query: "limit=1000", sortKey: "_id", paged: true, limit: 50, ok: true},
+		{name: "cursor of another sort", query: "sort=title&cursor=" + cursorOf(t, "_id")},
+		{name: "incorrect cursor", query: "cursor=!!"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v1/tasks?"+test.query, nil)
+
+			query, err := parseTaskQuery(r, userId)
+			if (err == nil) != test.ok {
+				t.Fatalf("parseTaskQuery() error = %v, want ok %v", err, test.ok)
+			}
+			if !test.ok {
+				return
+			}
+
+			filter := append(bson.D{{Key: "user", Value: userId}}, test.filter...)
+			if !reflect.DeepEqual(query.filter, filter) {
+				t.Errorf("parseTaskQuery() filter = %v, want %v", query.filter, filter)
+			}
+			if query.sortKey() != test.sortKey || query.paged != test.paged || query.limit != test.limit {
+				t.Errorf("parseTaskQuery() sort %q, paged %v, limit %d, want %q, %v, %d", query.sortKey(), query.paged, query.limit, test.sortKey, test.paged, test.limit)
+			}
+		})
+	}
+}
+
+// cursorOf returns a cursor of a query sorted with sort key.
+func cursorOf(t *testing.T, sortKey string) string {
+	query := taskQuery{sortField: sortKey, ascending: true}
+	cursor, err := query.nextCursor(models.Task{ID: primitive.NewObjectID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return url.QueryEscape(cursor)
+}
+
+func TestTaskCursor(t *testing.T) {
+	userId := primitive.NewObjectID()
+	id := primitive.NewObjectID()
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		query string
+		last  models.Task
+		// next is the condition of the tasks after last.
+		next bson.E
+	}{
+		{
+			name:  "created",
+			query: "limit=10",
+			last:  models.Task{ID: id, Title: "b"},
+			next:  bson.E{Key: "_id", Value: bson.M{"$gt": id}},
+		},
+		{
+			name:  "created descending",
+			query: "limit=10&order=desc",
+			last:  models.Task{ID: id},
+			next:  bson.E{Key: "_id", Value: bson.M{"$lt": id}},
+		},
+		{
+			name:  "title",
+			query: "sort=title",
+			last:  models.Task{ID: id, Title: "b"},
+			next: bson.E{Key: "$or", Value: bson.A{
+				bson.D{{Key: "title", Value: bson.M{"$gt": "b"}}},
+				bson.D{{Key: "title", Value: "b"}, {Key: "_id", Value: bson.M{"$gt": id}}},
+			}},
+		},
+		{
+			name:  "due descending",
+			query: "sort=due&order=desc",
+			last:  models.Task{ID: id, RepetitionBeginDay: day},
+			next: bson.E{Key: "$or", Value: bson.A{
+				bson.D{{Key: "repetitionbeginday", Value: bson.M{"$lt": primitive.NewDateTimeFromTime(day)}}},
+				bson.D{{Key: "repetitionbeginday", Value: primitive.NewDateTimeFromTime(day)}, {Key: "_id", Value: bson.M{"$lt": id}}},
+				bson.D{{Key: "repetitionbeginday", Value: nil}},
+			}},
+		},
+		{
+			name:  "missing due",
+			query: "sort=due",
+			last:  models.Task{ID: id},
+			next: bson.E{Key: "$or", Value: bson.A{
+				bson.D{{Key: "repetitionbeginday", Value: nil}, {Key: "_id", Value: bson.M{"$gt": id}}},
+				bson.D{{Key: "repetitionbeginday", Value: bson.M{"$ne": nil}}},
+			}},
+		},
+		{
+			name:  "missing title descending",
+			query: "sort=title&order=desc",
+			last:  models.Task{ID: id},
+			next: bson.E{Key: "$or", Value: bson.A{
+				bson.D{{Key: "title", Value: nil}, {Key: "_id", Value: bson.M{"$lt": id}}},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first, err := parseTaskQuery(httptest.NewRequest("GET", "/api/v1/tasks?"+test.query, nil), userId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(first.pageFilter(), first.filter) {
+				t.Errorf("pageFilter() of the first page = %v, want the filter", first.pageFilter())
+			}
+
+			cursor, err := first.nextCursor(test.last)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			next, err := parseTaskQuery(httptest.NewRequest("GET", "/api/v1/tasks?"+test.query+"&cursor="+cursor, nil), userId)
+			if err != nil {
+				t.Fatalf("parseTaskQuery() with the next cursor error = %v", err)
+			}
+			if !next.paged {
+				t.Error("query with a cursor is not paged")
+			}
+
+			want := bson.D{{Key: "user", Value: userId}, test.next}
+			if got := next.pageFilter(); !reflect.DeepEqual(got, want) {
+				t.Errorf("pageFilter() = %v, want %v", got, want)
+			}
+		})
+	}
+}
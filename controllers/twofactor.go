@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/auth"
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const recoveryCodeCount = 10
+
+// @route       POST /api/v1/me/2fa/enroll
+// @access      Private
+// @description Creates TOTP secret for user and returns otpauth URI for authenticator apps. It is enabled after confirmation.
+func (c Controller) EnrollTwoFactor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user models.User
+		var error models.Error
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "_id", Value: userId}}
+		if err := c.DB.Collection("users").FindOne(context.TODO(), filter).Decode(&user); err != nil {
+			error.Message = "Server Error."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if user.TOTPEnabled {
+			error.Message = "Two-factor authentication is already enabled."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		secret, err := auth.NewTOTPSecret()
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		_, err = c.DB.Collection("users").UpdateOne(
+			context.TODO(),
+			filter,
+			bson.D{{Key: "$set", Value: bson.D{{Key: "totpsecret", Value: secret}}}},
+		)
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		issuer := os.Getenv("TOTP_ISSUER")
+		if issuer == "" {
+			issuer = "Task Repetition"
+		}
+
+		utils.SendSuccess(w, struct {
+			Secret string
+			URI    string
+		}{secret, auth.TOTPURI(secret, issuer, user.Email)})
+	}
+}
+
+// @route       POST /api/v1/me/2fa/confirm
+// @access      Private
+// @description Enables two-factor authentication with a code of the enrolled secret and returns single-use recovery codes.
+func (c Controller) ConfirmTwoFactor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user models.User
+		var error models.Error
+		var request models.Credentials
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.Code == "" {
+			error.Message = "Enter missing fields. (Code)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "_id", Value: userId}}
+		if err := c.DB.Collection("users").FindOne(context.TODO(), filter).Decode(&user); err != nil {
+			error.Message = "Server Error."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if user.TOTPEnabled || user.TOTPSecret == "" {
+			error.Message = "There is no two-factor enrollment to confirm."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		step, ok := auth.ValidateTOTP(user.TOTPSecret, request.Code, time.Now(), user.TOTPLastStep)
+		if !ok {
+			error.Message = "Incorrect code."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		codes, hashes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		_, err = c.DB.Collection("users").UpdateOne(
+			context.TODO(),
+			filter,
+			bson.D{{Key: "$set", Value: bson.D{
+				{Key: "totpenabled", Value: true},
+				{Key: "totplaststep", Value: step},
+				{Key: "recoverycodes", Value: hashes},
+			}}},
+		)
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		//basic auth is not accepted with two-factor authentication.
+		c.Credentials.InvalidateUser(userId.Hex())
+
+		c.audit(r, AuditTwoFactorEnabled, userId, nil)
+
+		utils.SendSuccess(w, struct {
+			RecoveryCodes []string
+		}{codes})
+	}
+}
+
+// @route       DELETE /api/v1/me/2fa
+// @access      Private
+// @description Disables two-factor authentication with password and a code or recovery code.
+func (c Controller) DisableTwoFactor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user models.User
+		var error models.Error
+		var request models.Credentials
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.Password == "" || (request.Code == "" && request.RecoveryCode == "") {
+			error.Message = "Enter missing fields. (Password, Code or RecoveryCode)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		userId, err := currentUserID(r)
+		if err != nil {
+			error.Message = "Error while getting user."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "_id", Value: userId}}
+		if err := c.DB.Collection("users").FindOne(context.TODO(), filter).Decode(&user); err != nil {
+			error.Message = "Server Error."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		if !user.TOTPEnabled {
+			error.Message = "Two-factor authentication is not enabled."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		if !c.confirmPassword(user, request.Password, r) || !c.verifySecondFactor(user, request) {
+			error.Message = "Incorrect password or code."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		_, err = c.DB.Collection("users").UpdateOne(
+			context.TODO(),
+			filter,
+			bson.D{{Key: "$unset", Value: bson.D{
+				{Key: "totpenabled", Value: ""},
+				{Key: "totpsecret", Value: ""},
+				{Key: "totplaststep", Value: ""},
+				{Key: "recoverycodes", Value: ""},
+			}}},
+		)
+		if err != nil {
+			error.Message = "Server error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		c.audit(r, AuditTwoFactorDisabled, userId, nil)
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
+
+// @description Verifies TOTP code or recovery code of user. Used code can not be used again.
+func (c Controller) verifySecondFactor(user models.User, credentials models.Credentials) bool {
+	if credentials.Code != "" {
+		step, ok := auth.ValidateTOTP(user.TOTPSecret, credentials.Code, time.Now(), user.TOTPLastStep)
+		if !ok {
+			return false
+		}
+
+		//the step is only moved forward, so the same code is rejected by a parallel login.
+		result, err := c.DB.Collection("users").UpdateOne(
+			context.TODO(),
+			bson.D{{Key: "_id", Value: user.ID}, {Key: "totplaststep", Value: bson.M{"$lt": step}}},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "totplaststep", Value: step}}}},
+		)
+		return err == nil && result.ModifiedCount == 1
+	}
+
+	if credentials.RecoveryCode != "" {
+		recoveryCode := auth.HashToken(strings.ToLower(strings.TrimSpace(credentials.RecoveryCode)))
+		result, err := c.DB.Collection("users").UpdateOne(
+			context.TODO(),
+			bson.D{{Key: "_id", Value: user.ID}, {Key: "recoverycodes", Value: recoveryCode}},
+			bson.D{{Key: "$pull", Value: bson.D{{Key: "recoverycodes", Value: recoveryCode}}}},
+		)
+		return err == nil && result.ModifiedCount == 1
+	}
+
+	return false
+}
@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/auth"
+	"github.com/bberkgulay/task-repetition-go/models"
+	"github.com/bberkgulay/task-repetition-go/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// @route       POST /api/v1/auth/verify-email
+// @access      Public
+// @description Verifies email of user with the token sent by email.
+func (c Controller) VerifyEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var verification models.EmailVerification
+		var error models.Error
+		var request struct {
+			Token string
+		}
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.Token == "" {
+			error.Message = "Enter missing fields. (Token)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		now := time.Now()
+
+		filter := bson.D{
+			{Key: "tokenhash", Value: auth.HashToken(request.Token)},
+			{Key: "usedat", Value: bson.M{"$exists": false}},
+			{Key: "expiresat", Value: bson.M{"$gt": now}},
+		}
+		if err := c.DB.Collection("emailverifications").FindOne(context.TODO(), filter).Decode(&verification); err != nil {
+			error.Message = "Invalid or expired verification token"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		//email may have been taken by another user since the link was sent, then the token stays unused.
+		existedUser, err := c.DB.Collection("users").CountDocuments(
+			context.TODO(),
+			bson.D{{Key: "email", Value: verification.Email}, {Key: "_id", Value: bson.M{"$ne": verification.User}}},
+		)
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+		if existedUser > 0 {
+			error.Message = "That email is taken. Try another."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		//the token is used once even if it is sent twice at the same time.
+		claimed, err := c.DB.Collection("emailverifications").UpdateOne(
+			context.TODO(),
+			append(bson.D{{Key: "_id", Value: verification.ID}}, filter...),
+			bson.D{{Key: "$set", Value: bson.D{{Key: "usedat", Value: now}}}},
+		)
+		if err != nil || claimed.ModifiedCount == 0 {
+			error.Message = "Invalid or expired verification token"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		_, err = c.DB.Collection("users").UpdateOne(
+			context.TODO(),
+			bson.D{{Key: "_id", Value: verification.User}},
+			bson.D{{Key: "$set", Value: bson.D{
+				{Key: "email", Value: verification.Email},
+				{Key: "emailverified", Value: true},
+			}}},
+		)
+		if mongo.IsDuplicateKeyError(err) {
+			//another user took the email in between, the token can be used again once it is free.
+			c.DB.Collection("emailverifications").UpdateOne(
+				context.TODO(),
+				bson.D{{Key: "_id", Value: verification.ID}},
+				bson.D{{Key: "$unset", Value: bson.D{{Key: "usedat", Value: ""}}}},
+			)
+			error.Message = "That email is taken. Try another."
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+		if err != nil {
+			error.Message = "Server Error"
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		//basic auth credentials with the previous email are no longer valid.
+		c.Credentials.InvalidateUser(verification.User.Hex())
+
+		c.audit(r, AuditEmailVerified, verification.User, map[string]string{"email": verification.Email})
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
+
+// @route       POST /api/v1/auth/resend-verification
+// @access      Public
+// @description Sends verification email again to an unverified user. It always succeeds so that registered emails can not be discovered.
+func (c Controller) ResendVerification() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request models.User
+		var user models.User
+		var error models.Error
+
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if request.Email == "" {
+			error.Message = "Enter missing fields. (Email)"
+			utils.SendError(w, http.StatusBadRequest, error)
+			return
+		}
+
+		filter := bson.D{{Key: "email", Value: normalizeEmail(request.Email)}, {Key: "deletedat", Value: bson.M{"$exists": false}}}
+		if err := c.DB.Collection("users").FindOne(context.TODO(), filter).Decode(&user); err != nil || user.EmailVerified {
+			utils.SendSuccess(w, "Successful")
+			return
+		}
+
+		if err := c.sendEmailVerification(user, user.Email); err != nil {
+			error.Message = "Error while sending email."
+			utils.SendError(w, http.StatusInternalServerError, error)
+			return
+		}
+
+		utils.SendSuccess(w, "Successful")
+	}
+}
+
+// @description Creates verification token for the email of user and sends the link to that email. Earlier links of user are invalidated.
+func (c Controller) sendEmailVerification(user models.User, email string) error {
+	token, tokenHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	_, err = c.DB.Collection("emailverifications").UpdateMany(
+		context.TODO(),
+		bson.D{{Key: "user", Value: user.ID}, {Key: "usedat", Value: bson.M{"$exists": false}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "usedat", Value: now}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.DB.Collection("emailverifications").InsertOne(context.TODO(), models.EmailVerification{
+		User:      user.ID,
+		Email:     email,
+		TokenHash: tokenHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(utils.DurationFromEnv("EMAIL_VERIFICATION_TTL", 48*time.Hour)),
+	})
+	if err != nil {
+		return err
+	}
+
+	body := "Hello " + user.Name + ",\n\n" +
+		"Use the link below to verify your email address.\n\n" +
+		os.Getenv("APP_URL") + "/verify-email?token=" + url.QueryEscape(token) + "\n"
+
+	return c.Mailer.Send(email, "Verify your email", body)
+}
+
+// @description Marks the emails of users registered before email verification as verified, otherwise they could not
+// add tasks with REQUIRE_VERIFIED_EMAIL. Every user registered since then has an email verification, users without one
+// are these users. It is run at startup, already marked users are not matched again.
+func (c Controller) BackfillEmailVerification() (int64, error) {
+	verifiedUsers, err := c.DB.Collection("emailverifications").Distinct(context.TODO(), "user", bson.D{})
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := c.DB.Collection("users").UpdateMany(
+		context.TODO(),
+		bson.D{
+			{Key: "emailverified", Value: bson.M{"$exists": false}},
+			{Key: "_id", Value: bson.M{"$nin": verifiedUsers}},
+		},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "emailverified", Value: true}}}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// @description Stores emails of users, pending email changes and invites in lower case, as they are stored since emails
+// are unique. Returns the number of changed emails. Users whose emails only differ in case must be resolved by hand,
+// the unique index of emails can not be created otherwise.
+func (c Controller) NormalizeEmails() (int64, error) {
+	var count int64
+
+	for _, collection := range []string{"users", "emailverifications", "invites"} {
+		result, err := c.DB.Collection(collection).UpdateMany(
+			context.TODO(),
+			bson.D{{Key: "email", Value: bson.M{"$regex": "[A-Z]"}}},
+			mongo.Pipeline{bson.D{{Key: "$set", Value: bson.D{{Key: "email", Value: bson.D{{Key: "$toLower", Value: "$email"}}}}}}},
+		)
+		if err != nil {
+			return count, err
+		}
+		count += result.ModifiedCount
+	}
+
+	return count, nil
+}
+
+// @description Returns whether user has verified email. Always true unless REQUIRE_VERIFIED_EMAIL is enabled.
+func (c Controller) hasVerifiedEmail(userId primitive.ObjectID) bool {
+	var user models.User
+
+	if os.Getenv("REQUIRE_VERIFIED_EMAIL") != "true" {
+		return true
+	}
+
+	err := c.DB.Collection("users").FindOne(context.TODO(), bson.D{{Key: "_id", Value: userId}}).Decode(&user)
+
+	return err == nil && user.EmailVerified
+}
+
+// @description Email must be a bare address like name@example.com.
+func isValidEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+
+	return err == nil && address.Address == email
+}
+
+// @description Emails are stored and looked up in lower case, so that an address can only be registered once.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bberkgulay/task-repetition-go/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// error codes of MongoDB when an index exists with other options or another index exists with the same name.
+const (
+	indexOptionsConflict  = 85
+	indexKeySpecsConflict = 86
+)
+
+// indexes of collections by collection name. Queries of users always start with the user field.
+var indexes = map[string][]mongo.IndexModel{
+	"tasks": {
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "title", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "repetitionbeginday", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "repetitiontype", Value: 1}}},
+		//text indexes start with user so that a search is always restricted to the data of one user.
+		{
+			Keys: bson.D{
+				{Key: "user", Value: 1},
+				{Key: "title", Value: "text"},
+				{Key: "tags", Value: "text"},
+				{Key: "summary", Value: "text"},
+				{Key: "link", Value: "text"},
+			},
+			Options: options.Index().SetName("search").SetWeights(bson.D{
+				{Key: "title", Value: 10},
+				{Key: "tags", Value: 5},
+				{Key: "summary", Value: 2},
+				{Key: "link", Value: 1},
+			}),
+		},
+	},
+	//tokens are looked up by the hash of the secret on every use, hashes of random secrets are unique.
+	"apitokens": {
+		{Keys: bson.D{{Key: "tokenhash", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"refreshtokens": {
+		{Keys: bson.D{{Key: "tokenhash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "family", Value: 1}}},
+	},
+	"passwordresets": {
+		{Keys: bson.D{{Key: "tokenhash", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"emailverifications": {
+		{Keys: bson.D{{Key: "tokenhash", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"oidclogins": {
+		{Keys: bson.D{{Key: "statehash", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"sessions": {
+		{Keys: bson.D{{Key: "user", Value: 1}}},
+	},
+	"exports": {
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "status", Value: 1}}},
+	},
+	//the audit log is also ordered by creation, which is indexed with its retention.
+	"auditlogs": {
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "createdat", Value: -1}}},
+		{Keys: bson.D{{Key: "event", Value: 1}, {Key: "createdat", Value: -1}}},
+	},
+	"notes": {
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "task", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "user", Value: 1}, {Key: "note", Value: "text"}},
+			Options: options.Index().SetName("search"),
+		},
+	},
+}
+
+// EnsureIndexes creates the indexes of the application, existing indexes are left as they are. Emails of users are
+// unique, they must be stored in lower case before. Audit logs are removed after AUDIT_RETENTION (default 365 days).
+func EnsureIndexes(database *mongo.Database) error {
+	for collection, models := range indexes {
+		if _, err := database.Collection(collection).Indexes().CreateMany(context.TODO(), models); err != nil {
+			return err
+		}
+	}
+	if err := ensureUnique(database, "users", "email"); err != nil {
+		return err
+	}
+	return ensureRetention(database, "auditlogs", "createdat", utils.DurationFromEnv("AUDIT_RETENTION", 365*24*time.Hour))
+}
+
+// ensureRetention creates the TTL index removing documents of collection retention after the time in field. The
+// retention of an existing index is changed in place.
+func ensureRetention(database *mongo.Database, collection string, field string, retention time.Duration) error {
+	seconds := int32(retention.Seconds())
+
+	_, err := database.Collection(collection).Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetName("retention").SetExpireAfterSeconds(seconds),
+	})
+
+	var commandError mongo.CommandError
+	if errors.As(err, &commandError) && commandError.Code == indexOptionsConflict {
+		return database.RunCommand(context.TODO(), bson.D{
+			{Key: "collMod", Value: collection},
+			{Key: "index", Value: bson.D{{Key: "name", Value: "retention"}, {Key: "expireAfterSeconds", Value: seconds}}},
+		}).Err()
+	}
+	return err
+}
+
+// ensureUnique creates the unique index of field in collection. An earlier index of the field which is not unique is
+// replaced.
+func ensureUnique(database *mongo.Database, collection string, field string) error {
+	view := database.Collection(collection).Indexes()
+	model := mongo.IndexModel{Keys: bson.D{{Key: field, Value: 1}}, Options: options.Index().SetUnique(true)}
+
+	_, err := view.CreateOne(context.TODO(), model)
+
+	var commandError mongo.CommandError
+	if errors.As(err, &commandError) && (commandError.Code == indexOptionsConflict || commandError.Code == indexKeySpecsConflict) {
+		if _, err := view.DropOne(context.TODO(), field+"_1"); err != nil {
+			return err
+		}
+		_, err = view.CreateOne(context.TODO(), model)
+	}
+	return err
+}
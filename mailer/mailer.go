@@ -0,0 +1,111 @@
+// Package mailer sends the emails of the application, e.g. password reset links.
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer delivers a plain text email.
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// FromEnv returns the mailer configured with MAILER (smtp, file or log). It must be set explicitly, because the
+// file and log mailers keep the links of the emails, e.g. password reset tokens, readable outside of the inbox.
+func FromEnv() (Mailer, error) {
+	switch mailer := os.Getenv("MAILER"); mailer {
+	case "smtp":
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}, nil
+	case "file":
+		if os.Getenv("MAIL_FILE") == "" {
+			return nil, fmt.Errorf("MAILER=file needs MAIL_FILE")
+		}
+		return &FileMailer{Path: os.Getenv("MAIL_FILE")}, nil
+	case "log":
+		return &FileMailer{}, nil
+	case "":
+		return nil, fmt.Errorf("MAILER is not set, use smtp, or file or log for local development")
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q, use smtp, file or log", mailer)
+	}
+}
+
+// SMTPMailer sends emails through an SMTP server with PLAIN authentication.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, message(m.From, to, subject, body))
+}
+
+// FileMailer appends emails to a file instead of sending them, for local development and tests.
+// Emails are written to the application log when Path is empty.
+type FileMailer struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (m *FileMailer) Send(to string, subject string, body string) error {
+	content := message("", to, subject, body)
+
+	if m.Path == "" {
+		log.Printf("mail:\n%s", content)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s\n\n", content)
+	return err
+}
+
+func message(from string, to string, subject string, body string) []byte {
+	var builder strings.Builder
+
+	//header values must not break into new headers.
+	header := strings.NewReplacer("\r", "", "\n", "")
+	from, to, subject = header.Replace(from), header.Replace(to), header.Replace(subject)
+
+	if from != "" {
+		builder.WriteString("From: " + from + "\r\n")
+	}
+	builder.WriteString("To: " + to + "\r\n")
+	builder.WriteString("Subject: " + subject + "\r\n")
+	builder.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(body)
+
+	return []byte(builder.String())
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/bberkgulay/task-repetition-go/auth"
 	"github.com/bberkgulay/task-repetition-go/controllers"
 	"github.com/bberkgulay/task-repetition-go/db"
+	"github.com/bberkgulay/task-repetition-go/mailer"
 	"github.com/bberkgulay/task-repetition-go/utils"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -20,32 +23,131 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
+	if os.Getenv("JWT_SECRET") == "" {
+		log.Fatal("JWT_SECRET is not set")
+	}
+
 	database := db.Connect()
-	controller := controllers.Controller{DB: database}
+
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatal("Error while configuring mailer: ", err)
+	}
+
+	controller := controllers.Controller{
+		DB:          database,
+		Mailer:      mail,
+		Routes:      map[string]controllers.Route{},
+		Credentials: credentialCache(),
+		OIDC:        auth.OIDCProviderFromEnv(),
+		Directory:   directory(),
+	}
+
+	//emails are unique in lower case, earlier emails are changed before the index is created.
+	if count, err := controller.NormalizeEmails(); err != nil {
+		log.Fatal("Error while storing emails in lower case: ", err)
+	} else if count > 0 {
+		log.Printf("%d emails are stored in lower case", count)
+	}
+
+	if err := db.EnsureIndexes(database); err != nil {
+		log.Fatal("Error while creating indexes: ", err)
+	}
+
+	if err := controller.BootstrapAdmins(); err != nil {
+		log.Fatal("Error while giving admin role: ", err)
+	}
+
+	if err := controller.FailInterruptedExports(); err != nil {
+		log.Fatal("Error while failing interrupted exports: ", err)
+	}
+
+	if count, err := controller.BackfillEmailVerification(); err != nil {
+		log.Fatal("Error while marking emails of earlier users verified: ", err)
+	} else if count > 0 {
+		log.Printf("Emails of %d users registered before email verification are marked verified", count)
+	}
 
 	router := mux.NewRouter()
 
 	version := os.Getenv("VERSIONING")
-	// endpoints are defined and mapped with functions.
-	router.HandleFunc(version+"/auth/register", controller.Register()).Methods("POST")
-	router.HandleFunc(version+"/auth/login", controller.Login()).Methods("POST")
+	// endpoints are defined with their protection and mapped with functions.
+	controller.RegisterRoutes(router, version, []controllers.Route{
+		{Method: "POST", Path: "/auth/register", Handler: controller.Register(), Access: controllers.Public},
+		{Method: "POST", Path: "/auth/login", Handler: controller.Login(), Access: controllers.Public},
+		{Method: "POST", Path: "/auth/refresh", Handler: controller.Refresh(), Access: controllers.Public},
+		{Method: "POST", Path: "/auth/logout", Handler: controller.Logout(), Access: controllers.Public},
+		{Method: "POST", Path: "/auth/forgot-password", Handler: controller.ForgotPassword(), Access: controllers.Public},
+		{Method: "POST", Path: "/auth/reset-password", Handler: controller.ResetPassword(), Access: controllers.Public},
+		{Method: "POST", Path: "/auth/verify-email", Handler: controller.VerifyEmail(), Access: controllers.Public},
+		{Method: "POST", Path: "/auth/resend-verification", Handler: controller.ResendVerification(), Access: controllers.Public},
+		{Method: "POST", Path: "/auth/restore", Handler: controller.RestoreAccount(), Access: controllers.Public},
+		{Method: "GET", Path: "/auth/oidc/login", Handler: controller.OIDCLogin(), Access: controllers.Public},
+		{Method: "GET", Path: "/auth/oidc/callback", Handler: controller.OIDCCallback(), Access: controllers.Public},
+		{Method: "POST", Path: "/auth/oidc/second-factor", Handler: controller.OIDCSecondFactor(), Access: controllers.Public},
+
+		{Method: "GET", Path: "/tasks", Handler: controller.GetTasks(), Scope: auth.ScopeTasksRead},
+		{Method: "POST", Path: "/tasks", Handler: controller.AddTask(), Scope: auth.ScopeTasksWrite},
+		{Method: "GET", Path: "/tasks/due", Handler: controller.GetDueTasks(), Scope: auth.ScopeTasksRead},
+		{Method: "GET", Path: "/tasks/{id}", Handler: controller.GetTask(), Scope: auth.ScopeTasksRead},
+		{Method: "GET", Path: "/search", Handler: controller.Search(), Scope: auth.ScopeTasksRead},
+		{Method: "PUT", Path: "/tasks/{id}", Handler: controller.UpdateTask(), Scope: auth.ScopeTasksWrite},
+		{Method: "PATCH", Path: "/tasks/{id}", Handler: controller.PatchTask(), Scope: auth.ScopeTasksWrite},
+		{Method: "DELETE", Path: "/tasks/{id}", Handler: controller.DeleteTask(), Scope: auth.ScopeTasksWrite},
 
-	router.HandleFunc(version+"/tasks", controller.GetTasks()).Methods("GET")
-	router.HandleFunc(version+"/tasks", controller.AddTask()).Methods("POST")
-	router.HandleFunc(version+"/tasks/{id}", controller.GetTask()).Methods("GET")
-	router.HandleFunc(version+"/tasks/{id}", controller.UpdateTask()).Methods("PUT")
-	router.HandleFunc(version+"/tasks/{id}", controller.DeleteTask()).Methods("DELETE")
+		{Method: "PUT", Path: "/tasks/{id}/complete", Handler: controller.CompleteTask(), Scope: auth.ScopeTasksWrite},
 
-	router.HandleFunc(version+"/tasks/{id}/complete", controller.CompleteTask()).Methods("PUT")
+		{Method: "POST", Path: "/tasks/{task_id}/notes", Handler: controller.AddNote(), Scope: auth.ScopeNotesWrite},
+		{Method: "GET", Path: "/tasks/{task_id}/notes", Handler: controller.GetNotes(), Scope: auth.ScopeNotesRead},
+		{Method: "GET", Path: "/notes/{id}", Handler: controller.GetNote(), Scope: auth.ScopeNotesRead},
+		{Method: "PUT", Path: "/notes/{id}", Handler: controller.UpdateNote(), Scope: auth.ScopeNotesWrite},
+		{Method: "DELETE", Path: "/notes/{id}", Handler: controller.DeleteNote(), Scope: auth.ScopeNotesWrite},
 
-	router.HandleFunc(version+"/tasks/{task_id}/notes", controller.AddNote()).Methods("POST")
-	router.HandleFunc(version+"/tasks/{task_id}/notes", controller.GetNotes()).Methods("GET")
-	router.HandleFunc(version+"/notes/{id}", controller.DeleteNote()).Methods("DELETE")
+		{Method: "GET", Path: "/repetitiontypes", Handler: controller.GetRepetitionTypes(), Scope: auth.ScopeRepetitionTypesRead},
+		{Method: "POST", Path: "/repetitiontypes/optimize", Handler: controller.OptimizeRepetitionTypes(), Scope: auth.ScopeRepetitionTypesWrite},
+		{Method: "PUT", Path: "/repetitiontypes/proposals/{id}/accept", Handler: controller.AcceptLadderProposal(), Scope: auth.ScopeRepetitionTypesWrite},
 
-	router.HandleFunc(version+"/repetitiontypes", controller.GetRepetitionTypes()).Methods("GET")
+		{Method: "GET", Path: "/me", Handler: controller.GetProfile()},
+		{Method: "PUT", Path: "/me", Handler: controller.UpdateProfile()},
+		{Method: "PUT", Path: "/me/password", Handler: controller.ChangePassword()},
+		{Method: "PUT", Path: "/me/email", Handler: controller.ChangeEmail()},
+		{Method: "DELETE", Path: "/me", Handler: controller.DeleteAccount()},
+		{Method: "POST", Path: "/me/2fa/enroll", Handler: controller.EnrollTwoFactor()},
+		{Method: "POST", Path: "/me/2fa/confirm", Handler: controller.ConfirmTwoFactor()},
+		{Method: "DELETE", Path: "/me/2fa", Handler: controller.DisableTwoFactor()},
+		{Method: "GET", Path: "/me/sessions", Handler: controller.GetSessions()},
+		{Method: "DELETE", Path: "/me/sessions", Handler: controller.RevokeOtherSessions()},
+		{Method: "DELETE", Path: "/me/sessions/{id}", Handler: controller.RevokeSession()},
+		{Method: "GET", Path: "/me/audit", Handler: controller.GetMyAuditLogs()},
+		{Method: "GET", Path: "/me/export", Handler: controller.ExportAccount()},
+		{Method: "GET", Path: "/me/exports/{id}", Handler: controller.GetExport()},
+		{Method: "GET", Path: "/me/exports/{id}/download", Handler: controller.DownloadExport()},
+
+		{Method: "POST", Path: "/tokens", Handler: controller.CreateAPIToken()},
+		{Method: "GET", Path: "/tokens", Handler: controller.GetAPITokens()},
+		{Method: "DELETE", Path: "/tokens/{id}", Handler: controller.RevokeAPIToken()},
+
+		{Method: "POST", Path: "/admin/unlock", Handler: controller.UnlockLogin(), Access: controllers.Admin},
+		{Method: "GET", Path: "/admin/users", Handler: controller.GetUsers(), Access: controllers.Admin},
+		{Method: "PUT", Path: "/admin/users/{id}/disable", Handler: controller.DisableUser(), Access: controllers.Admin},
+		{Method: "PUT", Path: "/admin/users/{id}/enable", Handler: controller.EnableUser(), Access: controllers.Admin},
+		{Method: "PUT", Path: "/admin/users/{id}/roles", Handler: controller.SetUserRoles(), Access: controllers.Admin},
+		{Method: "POST", Path: "/admin/invites", Handler: controller.CreateInvite(), Access: controllers.Admin},
+		{Method: "GET", Path: "/admin/invites", Handler: controller.GetInvites(), Access: controllers.Admin},
+		{Method: "DELETE", Path: "/admin/invites/{id}", Handler: controller.RevokeInvite(), Access: controllers.Admin},
+		{Method: "GET", Path: "/admin/audit", Handler: controller.GetAuditLogs(), Access: controllers.Admin},
+		{Method: "GET", Path: "/admin/stats", Handler: controller.GetStats(), Access: controllers.Admin},
+		{Method: "POST", Path: "/admin/repetitiontypes", Handler: controller.AddRepetitionType(), Access: controllers.Admin},
+		{Method: "PUT", Path: "/admin/repetitiontypes/{id}", Handler: controller.UpdateRepetitionType(), Access: controllers.Admin},
+		{Method: "DELETE", Path: "/admin/repetitiontypes/{id}", Handler: controller.DeleteRepetitionType(), Access: controllers.Admin},
+	})
 
 	router.Use(controller.LoginControl)
 
+	// deleted accounts are purged after their grace period.
+	go controller.RunAccountPurge(context.Background(), time.Hour)
+	go controller.RunExportCleanup(context.Background(), time.Hour)
+
 	srv := &http.Server{
 		Handler:      utils.Headers(router), // Set header to routes
 		Addr:         ":" + os.Getenv("PORT"),
@@ -56,3 +158,19 @@ func main() {
 	log.Println("Application is running at ", os.Getenv("PORT"))
 	log.Fatal(srv.ListenAndServe())
 }
+
+// credentialCache returns the basic auth credential cache, CREDENTIAL_CACHE_TTL=0 disables it.
+func credentialCache() *auth.CredentialCache {
+	if os.Getenv("CREDENTIAL_CACHE_TTL") == "0" {
+		return nil
+	}
+	return auth.NewCredentialCache(utils.DurationFromEnv("CREDENTIAL_CACHE_TTL", time.Minute), utils.IntFromEnv("CREDENTIAL_CACHE_SIZE", 10000))
+}
+
+// directory returns the LDAP directory if it is configured. A nil *LDAPDirectory would not be a nil auth.Directory.
+func directory() auth.Directory {
+	if ldapDirectory := auth.LDAPDirectoryFromEnv(); ldapDirectory != nil {
+		return ldapDirectory
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIToken is a personal access token of user for scripts and integrations.
+// Token is only filled in the response of creation, it is stored hashed.
+type APIToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	User       primitive.ObjectID `bson:"user,omitempty"`
+	Name       string             `bson:"name,omitempty"`
+	Token      string             `bson:"-" json:",omitempty"`
+	Prefix     string             `bson:"prefix,omitempty"`
+	TokenHash  string             `bson:"tokenhash,omitempty" json:"-"`
+	Scopes     []string           `bson:"scopes,omitempty"`
+	CreatedAt  time.Time          `bson:"createdat,omitempty"`
+	LastUsedAt time.Time          `bson:"lastusedat,omitempty"`
+	ExpiresAt  time.Time          `bson:"expiresat,omitempty"`
+	RevokedAt  time.Time          `bson:"revokedat,omitempty"`
+}
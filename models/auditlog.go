@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditLog is a security relevant event. User is the account the event is about,
+// Actor is the authenticated user who caused it if it is someone else, e.g. an admin.
+type AuditLog struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Event     string             `bson:"event,omitempty"`
+	User      primitive.ObjectID `bson:"user,omitempty"`
+	Actor     primitive.ObjectID `bson:"actor,omitempty"`
+	IP        string             `bson:"ip,omitempty"`
+	UserAgent string             `bson:"useragent,omitempty"`
+	Details   map[string]string  `bson:"details,omitempty"`
+	CreatedAt time.Time          `bson:"createdat,omitempty"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EmailVerification is a single-use token which confirms that user owns Email, stored hashed.
+type EmailVerification struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	User      primitive.ObjectID `bson:"user,omitempty"`
+	Email     string             `bson:"email,omitempty"`
+	TokenHash string             `bson:"tokenhash,omitempty"`
+	CreatedAt time.Time          `bson:"createdat,omitempty"`
+	ExpiresAt time.Time          `bson:"expiresat,omitempty"`
+	UsedAt    time.Time          `bson:"usedat,omitempty"`
+}
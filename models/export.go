@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+	// ExportExpired jobs are done but their archive is deleted after EXPORT_TTL.
+	ExportExpired = "expired"
+)
+
+// Export is a background job which archives all data of user.
+type Export struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	User        primitive.ObjectID `bson:"user,omitempty"`
+	Status      string             `bson:"status,omitempty"`
+	Error       string             `bson:"error,omitempty" json:",omitempty"`
+	File        string             `bson:"file,omitempty" json:"-"`
+	CreatedAt   time.Time          `bson:"createdat,omitempty"`
+	CompletedAt time.Time          `bson:"completedat,omitempty"`
+	DownloadURL string             `bson:"-" json:",omitempty"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invite is a registration invite code created by an admin. Code is only filled in the response of
+// creation, it is stored hashed. It can be used MaxUses times, only by Email if it is set.
+type Invite struct {
+	ID         primitive.ObjectID   `bson:"_id,omitempty"`
+	Code       string               `bson:"-" json:",omitempty"`
+	Prefix     string               `bson:"prefix,omitempty"`
+	CodeHash   string               `bson:"codehash,omitempty" json:"-"`
+	Email      string               `bson:"email,omitempty"`
+	MaxUses    int                  `bson:"maxuses,omitempty"`
+	Uses       int                  `bson:"uses"`
+	UsedBy     []primitive.ObjectID `bson:"usedby,omitempty"`
+	CreatedBy  primitive.ObjectID   `bson:"createdby,omitempty"`
+	CreatedAt  time.Time            `bson:"createdat,omitempty"`
+	LastUsedAt time.Time            `bson:"lastusedat,omitempty"`
+	ExpiresAt  time.Time            `bson:"expiresat,omitempty"`
+	RevokedAt  time.Time            `bson:"revokedat,omitempty"`
+}
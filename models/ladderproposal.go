@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type LadderProposalStep struct {
+	Order           int     `bson:"order"`
+	Name            string  `bson:"name,omitempty"`
+	CurrentDay      int     `bson:"currentday"`
+	ProposedDay     int     `bson:"proposedday"`
+	Reviews         int     `bson:"reviews"`
+	Stability       float64 `bson:"stability"`
+	RetentionBefore float64 `bson:"retentionbefore"`
+	RetentionAfter  float64 `bson:"retentionafter"`
+}
+
+// LadderProposal is an optimized repetition ladder fitted to the review
+// history of a user, waiting to be accepted.
+type LadderProposal struct {
+	ID              primitive.ObjectID   `bson:"_id,omitempty"`
+	User            primitive.ObjectID   `bson:"user,omitempty"`
+	TargetRetention float64              `bson:"targetretention"`
+	RetentionBefore float64              `bson:"retentionbefore"`
+	RetentionAfter  float64              `bson:"retentionafter"`
+	Steps           []LadderProposalStep `bson:"steps"`
+	CreatedAt       time.Time            `bson:"createdat,omitempty"`
+	AcceptedAt      time.Time            `bson:"acceptedat,omitempty"`
+}
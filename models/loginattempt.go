@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// LoginAttempt tracks failed logins of an account ("account:<email>") or of an IP address ("ip:<address>").
+type LoginAttempt struct {
+	Key           string    `bson:"_id"`
+	Failures      int       `bson:"failures"`
+	LastFailureAt time.Time `bson:"lastfailureat,omitempty"`
+	LockedUntil   time.Time `bson:"lockeduntil,omitempty"`
+}
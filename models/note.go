@@ -1,6 +1,10 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type Note struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty"`
@@ -8,4 +12,6 @@ type Note struct {
 	Important bool               `bson:"important,omitempty"`
 	User      primitive.ObjectID `bson:"user,omitempty"`
 	Task      primitive.ObjectID `bson:"task,omitempty"`
+	Version   int64              `bson:"version,omitempty"`
+	UpdatedAt time.Time          `bson:"updatedat,omitempty"`
 }
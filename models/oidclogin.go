@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OIDCLogin is a started single sign-on login waiting for the callback of the provider.
+// It is found by the hash of state and used once. Logins of users with two-factor authentication
+// wait for the code after the callback, then User is set and StateHash is the hash of the challenge token.
+type OIDCLogin struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	StateHash    string             `bson:"statehash,omitempty"`
+	Nonce        string             `bson:"nonce,omitempty"`
+	CodeVerifier string             `bson:"codeverifier,omitempty"`
+	User         primitive.ObjectID `bson:"user,omitempty"`
+	CreatedAt    time.Time          `bson:"createdat,omitempty"`
+	ExpiresAt    time.Time          `bson:"expiresat,omitempty"`
+}
+
+// SecondFactorChallenge is returned by the single sign-on callback for users with two-factor authentication.
+// The login is finished by sending the token with a code.
+type SecondFactorChallenge struct {
+	SecondFactorToken string
+	ExpiresAt         time.Time
+}
+
+// Identity is an external identity of user, the subject of an OpenID Connect issuer.
+type Identity struct {
+	Issuer  string `bson:"issuer,omitempty"`
+	Subject string `bson:"subject,omitempty"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PasswordReset is a single-use reset token of user, stored hashed.
+type PasswordReset struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	User      primitive.ObjectID `bson:"user,omitempty"`
+	TokenHash string             `bson:"tokenhash,omitempty"`
+	CreatedAt time.Time          `bson:"createdat,omitempty"`
+	ExpiresAt time.Time          `bson:"expiresat,omitempty"`
+	UsedAt    time.Time          `bson:"usedat,omitempty"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Profile is the public view of User, it never contains the password hash.
+type Profile struct {
+	ID            primitive.ObjectID
+	Name          string
+	Surname       string
+	Email         string
+	EmailVerified bool
+	Roles         []string
+	TOTPEnabled   bool
+}
+
+func NewProfile(user User) Profile {
+	return Profile{
+		ID:            user.ID,
+		Name:          user.Name,
+		Surname:       user.Surname,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Roles:         user.Roles,
+		TOTPEnabled:   user.TOTPEnabled,
+	}
+}
+
+// AccountSummary is the view of User for admins.
+type AccountSummary struct {
+	Profile
+	Disabled  bool
+	DeletedAt time.Time
+}
+
+func NewAccountSummary(user User) AccountSummary {
+	return AccountSummary{
+		Profile:   NewProfile(user),
+		Disabled:  user.Disabled,
+		DeletedAt: user.DeletedAt,
+	}
+}
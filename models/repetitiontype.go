@@ -7,4 +7,5 @@ type RepetitionType struct {
 	Name  string             `bson:"name,omitempty"`
 	Order int                `bson:"order,omitempty"`
 	Day   int                `bson:"day,omitempty"`
+	User  primitive.ObjectID `bson:"user,omitempty"`
 }
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Review is logged every time a scheduled repetition of a task is completed.
+// Interval is the number of days elapsed since the previous review.
+type Review struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	User           primitive.ObjectID `bson:"user,omitempty"`
+	Task           primitive.ObjectID `bson:"task,omitempty"`
+	RepetitionType primitive.ObjectID `bson:"repetitiontype,omitempty"`
+	Interval       float64            `bson:"interval"`
+	Recalled       bool               `bson:"recalled"`
+	ReviewedAt     time.Time          `bson:"reviewedat,omitempty"`
+}
@@ -0,0 +1,22 @@
+package models
+
+// SearchResult is a task found by search with its matching notes. Score is the best relevance of the task and its notes.
+type SearchResult struct {
+	Task       Task
+	Score      float64
+	Highlights []Highlight `json:",omitempty"`
+	Notes      []NoteMatch `json:",omitempty"`
+}
+
+// NoteMatch is a note found by search.
+type NoteMatch struct {
+	Note       Note
+	Score      float64
+	Highlights []Highlight `json:",omitempty"`
+}
+
+// Highlight is a fragment of a field with the matched words marked by <mark>, the rest of the fragment is HTML escaped.
+type Highlight struct {
+	Field    string
+	Fragment string
+}
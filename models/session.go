@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is a login of user on a device. Refresh tokens of the session have its ID as family
+// and access tokens carry it, so revoking the session ends the login on that device.
+type Session struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	User       primitive.ObjectID `bson:"user,omitempty"`
+	UserAgent  string             `bson:"useragent,omitempty"`
+	IP         string             `bson:"ip,omitempty"`
+	CreatedAt  time.Time          `bson:"createdat,omitempty"`
+	LastSeenAt time.Time          `bson:"lastseenat,omitempty"`
+	ExpiresAt  time.Time          `bson:"expiresat,omitempty"`
+	RevokedAt  time.Time          `bson:"revokedat,omitempty"`
+	Current    bool               `bson:"-" json:",omitempty"`
+}
@@ -16,4 +16,15 @@ type Task struct {
 	RepetitionType     primitive.ObjectID `bson:"repetitiontype,omitempty"`
 	RepetitionBeginDay time.Time          `bson:"repetitionbeginday,omitempty"`
 	CompletedDay       time.Time          `bson:"completedday,omitempty"`
+	LastReviewDay      time.Time          `bson:"lastreviewday,omitempty"`
+	Version            int64              `bson:"version,omitempty"`
+	UpdatedAt          time.Time          `bson:"updatedat,omitempty"`
+	Retrievability     *float64           `bson:"-" json:",omitempty"`
+}
+
+// TaskPage is a page of tasks. NextCursor is empty on the last page, Total counts all matching tasks.
+type TaskPage struct {
+	Tasks      []Task
+	Total      int64
+	NextCursor string `json:",omitempty"`
 }
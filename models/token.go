@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken is stored hashed. Every refresh replaces the token with a new one
+// of the same family, reusing a replaced token revokes the whole family.
+// Family is the ID of the session the token belongs to.
+type RefreshToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	User       primitive.ObjectID `bson:"user,omitempty"`
+	Family     primitive.ObjectID `bson:"family,omitempty"`
+	TokenHash  string             `bson:"tokenhash,omitempty"`
+	CreatedAt  time.Time          `bson:"createdat,omitempty"`
+	ExpiresAt  time.Time          `bson:"expiresat,omitempty"`
+	RevokedAt  time.Time          `bson:"revokedat,omitempty"`
+	ReplacedBy primitive.ObjectID `bson:"replacedby,omitempty"`
+}
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	TokenType    string
+	ExpiresIn    int
+}
@@ -1,11 +1,32 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type User struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	Name     string             `bson:"name,omitempty"`
-	Surname  string             `bson:"surname,omitempty"`
-	Email    string             `bson:"email,omitempty"`
-	Password string             `bson:"password,omitempty"`
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	Name          string             `bson:"name,omitempty"`
+	Surname       string             `bson:"surname,omitempty"`
+	Email         string             `bson:"email,omitempty"`
+	Password      string             `bson:"password,omitempty"`
+	EmailVerified bool               `bson:"emailverified,omitempty"`
+	Roles         []string           `bson:"roles,omitempty"`
+	Disabled      bool               `bson:"disabled,omitempty"`
+	DeletedAt     time.Time          `bson:"deletedat,omitempty"`
+	TOTPSecret    string             `bson:"totpsecret,omitempty"`
+	TOTPEnabled   bool               `bson:"totpenabled,omitempty"`
+	TOTPLastStep  int64              `bson:"totplaststep,omitempty"`
+	RecoveryCodes []string           `bson:"recoverycodes,omitempty"`
+	Identities    []Identity         `bson:"identities,omitempty" json:"-"`
+}
+
+// Credentials are sent to login. Code or RecoveryCode is required when two-factor authentication is enabled.
+type Credentials struct {
+	Email        string
+	Password     string
+	Code         string
+	RecoveryCode string
 }
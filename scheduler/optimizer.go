@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// priorWeight is the number of pseudo-reviews that anchor a step to its
+	// current interval, so steps with few or one-sided outcomes stay sane.
+	priorWeight = 2.0
+
+	minStability = 0.1
+	maxStability = 3650.0
+)
+
+// Step is one entry of a repetition ladder.
+type Step struct {
+	Order int
+	Name  string
+	Day   int
+}
+
+// Observation is a single logged review: how many days had passed since the
+// previous review and whether the task was recalled.
+type Observation struct {
+	Elapsed  float64
+	Recalled bool
+}
+
+// ProposedStep is the optimizer output for one ladder step.
+type ProposedStep struct {
+	Order           int
+	Name            string
+	CurrentDay      int
+	ProposedDay     int
+	Reviews         int
+	Stability       float64
+	RetentionBefore float64
+	RetentionAfter  float64
+}
+
+// Proposal is an optimized ladder with the predicted retention at due time
+// before and after applying it, averaged over the ladder steps.
+type Proposal struct {
+	TargetRetention float64
+	RetentionBefore float64
+	RetentionAfter  float64
+	Steps           []ProposedStep
+}
+
+// FitStability estimates the stability of a step from its review outcomes by
+// maximum likelihood. The current interval of the step is used as a prior,
+// assuming it was tuned for the target retention.
+func FitStability(observations []Observation, currentDay int, target float64) float64 {
+	prior := StabilityForInterval(float64(currentDay), target)
+
+	logLikelihood := func(stability float64) float64 {
+		sum := 0.0
+		add := func(weight float64, elapsed float64, recalled float64) {
+			p := Retention(elapsed, stability)
+			p = math.Min(math.Max(p, 1e-9), 1-1e-9)
+			sum += weight * (recalled*math.Log(p) + (1-recalled)*math.Log(1-p))
+		}
+		if prior > 0 {
+			add(priorWeight, float64(currentDay), target)
+		}
+		for _, o := range observations {
+			recalled := 0.0
+			if o.Recalled {
+				recalled = 1
+			}
+			add(1, o.Elapsed, recalled)
+		}
+		return sum
+	}
+
+	// golden-section search on log(stability), the likelihood is unimodal in it.
+	lo, hi := math.Log(minStability), math.Log(maxStability)
+	ratio := (math.Sqrt(5) - 1) / 2
+	a := hi - ratio*(hi-lo)
+	b := lo + ratio*(hi-lo)
+	fa, fb := logLikelihood(math.Exp(a)), logLikelihood(math.Exp(b))
+	for i := 0; i < 100 && hi-lo > 1e-6; i++ {
+		if fa > fb {
+			hi, b, fb = b, a, fa
+			a = hi - ratio*(hi-lo)
+			fa = logLikelihood(math.Exp(a))
+		} else {
+			lo, a, fa = a, b, fb
+			b = lo + ratio*(hi-lo)
+			fb = logLikelihood(math.Exp(b))
+		}
+	}
+
+	return math.Exp((lo + hi) / 2)
+}
+
+// Optimize fits every step of the ladder to its observations and proposes the
+// day offsets that reach the target retention. Steps with fewer than
+// minReviews observations keep their current day. Proposed days never
+// decrease along the ladder.
+func Optimize(steps []Step, observations map[int][]Observation, target float64, minReviews int) Proposal {
+	sorted := make([]Step, len(steps))
+	copy(sorted, steps)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Order < sorted[j].Order })
+
+	proposal := Proposal{TargetRetention: target}
+	previousDay := 1
+
+	for _, step := range sorted {
+		stepObservations := observations[step.Order]
+		stability := FitStability(stepObservations, step.Day, target)
+
+		proposedDay := step.Day
+		if len(stepObservations) >= minReviews {
+			proposedDay = int(math.Round(IntervalForStability(stability, target)))
+		}
+		if proposedDay < previousDay {
+			proposedDay = previousDay
+		}
+		previousDay = proposedDay
+
+		proposal.Steps = append(proposal.Steps, ProposedStep{
+			Order:           step.Order,
+			Name:            step.Name,
+			CurrentDay:      step.Day,
+			ProposedDay:     proposedDay,
+			Reviews:         len(stepObservations),
+			Stability:       stability,
+			RetentionBefore: Retention(float64(step.Day), stability),
+			RetentionAfter:  Retention(float64(proposedDay), stability),
+		})
+	}
+
+	if len(proposal.Steps) > 0 {
+		for _, step := range proposal.Steps {
+			proposal.RetentionBefore += step.RetentionBefore
+			proposal.RetentionAfter += step.RetentionAfter
+		}
+		proposal.RetentionBefore /= float64(len(proposal.Steps))
+		proposal.RetentionAfter /= float64(len(proposal.Steps))
+	}
+
+	return proposal
+}
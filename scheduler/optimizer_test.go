@@ -0,0 +1,99 @@
+package scheduler
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFitStability(t *testing.T) {
+	tests := []struct {
+		name         string
+		observations []Observation
+		currentDay   int
+		min, max     float64
+	}{
+		{"no reviews keeps the prior", nil, 7, StabilityForInterval(7, 0.9) * 0.99, StabilityForInterval(7, 0.9) * 1.01},
+		{"no reviews and no interval stays in bounds", nil, 0, minStability, maxStability},
+		{"single recall", []Observation{{Elapsed: 7, Recalled: true}}, 7, StabilityForInterval(7, 0.9), maxStability},
+		{"single lapse", []Observation{{Elapsed: 7, Recalled: false}}, 7, minStability, StabilityForInterval(7, 0.9)},
+		{"only lapses stay above the minimum", repeat(Observation{Elapsed: 1, Recalled: false}, 50), 1, minStability, 1},
+		{"only recalls stay below the maximum", repeat(Observation{Elapsed: 365, Recalled: true}, 50), 365, StabilityForInterval(365, 0.9), maxStability},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stability := FitStability(test.observations, test.currentDay, 0.9)
+			if math.IsNaN(stability) || stability < test.min || stability > test.max {
+				t.Errorf("FitStability() = %v, want between %v and %v", stability, test.min, test.max)
+			}
+		})
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	ladder := []Step{{Order: 2, Name: "week", Day: 7}, {Order: 1, Name: "day", Day: 1}}
+
+	tests := []struct {
+		name         string
+		steps        []Step
+		observations map[int][]Observation
+		minReviews   int
+		want         []int
+	}{
+		{"empty ladder", nil, nil, 5, nil},
+		{"no reviews keep the days", ladder, nil, 5, []int{1, 7}},
+		{"single review is below minimum", ladder, map[int][]Observation{2: {{Elapsed: 7, Recalled: false}}}, 5, []int{1, 7}},
+		{"reviews at the target keep the days", ladder, map[int][]Observation{
+			2: append(repeat(Observation{Elapsed: 7, Recalled: true}, 90), repeat(Observation{Elapsed: 7, Recalled: false}, 10)...),
+		}, 5, []int{1, 7}},
+		{"frequent lapses shorten the step", ladder, map[int][]Observation{
+			2: append(repeat(Observation{Elapsed: 7, Recalled: true}, 50), repeat(Observation{Elapsed: 7, Recalled: false}, 50)...),
+		}, 5, []int{1, 1}},
+		{"days never decrease along the ladder", ladder, map[int][]Observation{
+			1: repeat(Observation{Elapsed: 1, Recalled: true}, 100),
+			2: repeat(Observation{Elapsed: 7, Recalled: false}, 100),
+		}, 5, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proposal := Optimize(test.steps, test.observations, 0.9, test.minReviews)
+
+			if len(proposal.Steps) != len(test.steps) {
+				t.Fatalf("Optimize() has %d steps, want %d", len(proposal.Steps), len(test.steps))
+			}
+			if math.IsNaN(proposal.RetentionBefore) || math.IsNaN(proposal.RetentionAfter) {
+				t.Errorf("Optimize() retention is NaN")
+			}
+
+			for i, step := range proposal.Steps {
+				if i > 0 && (step.Order < proposal.Steps[i-1].Order || step.ProposedDay < proposal.Steps[i-1].ProposedDay) {
+					t.Errorf("step %d (order %d, day %d) is before the previous step", i, step.Order, step.ProposedDay)
+				}
+				if test.want != nil && step.ProposedDay != test.want[i] {
+					t.Errorf("step %d ProposedDay = %d, want %d", step.Order, step.ProposedDay, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRetentionIntervalRoundTrip(t *testing.T) {
+	for _, interval := range []float64{1, 7, 30, 365} {
+		stability := StabilityForInterval(interval, 0.9)
+		if got := Retention(interval, stability); math.Abs(got-0.9) > 1e-9 {
+			t.Errorf("Retention(%v) = %v, want 0.9", interval, got)
+		}
+		if got := IntervalForStability(stability, 0.9); math.Abs(got-interval) > 1e-9 {
+			t.Errorf("IntervalForStability() = %v, want %v", got, interval)
+		}
+	}
+}
+
+func repeat(observation Observation, count int) []Observation {
+	observations := make([]Observation, count)
+	for i := range observations {
+		observations[i] = observation
+	}
+	return observations
+}
@@ -0,0 +1,41 @@
+// Package scheduler contains the memory model used to reason about
+// repetition intervals. Recall is modelled with an exponential forgetting
+// curve R(t) = exp(-t/S), where t is the elapsed time in days and S is the
+// stability of the memory for a repetition step.
+package scheduler
+
+import "math"
+
+// DefaultTargetRetention is the recall probability the repetition ladder
+// aims for at the moment a task becomes due.
+const DefaultTargetRetention = 0.9
+
+// Retention returns the probability of recall after elapsed days for a memory
+// with the given stability.
+func Retention(elapsed float64, stability float64) float64 {
+	if stability <= 0 {
+		return 0
+	}
+	if elapsed <= 0 {
+		return 1
+	}
+	return math.Exp(-elapsed / stability)
+}
+
+// StabilityForInterval returns the stability at which recall drops to the
+// target retention exactly after interval days.
+func StabilityForInterval(interval float64, target float64) float64 {
+	if interval <= 0 || target <= 0 || target >= 1 {
+		return 0
+	}
+	return -interval / math.Log(target)
+}
+
+// IntervalForStability returns the number of days after which recall drops to
+// the target retention for a memory with the given stability.
+func IntervalForStability(stability float64, target float64) float64 {
+	if stability <= 0 || target <= 0 || target >= 1 {
+		return 0
+	}
+	return -stability * math.Log(target)
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETag returns the strong entity tag of a version of a document.
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ParseETag returns the version of a strong entity tag made by ETag, false for weak or other tags.
+func ParseETag(tag string) (int64, bool) {
+	tag = strings.TrimSpace(tag)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	return version, err == nil
+}
+
+// NoneMatch reports whether If-None-Match of the request does not contain etag. Tags are compared weakly.
+func NoneMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return false
+		}
+	}
+	return true
+}
+
+// SendWithETag sends data with the ETag header, or 304 Not Modified without body if the client has it already.
+// An empty etag is replaced with a weak tag of the content.
+func SendWithETag(w http.ResponseWriter, r *http.Request, etag string, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if etag == "" {
+		sum := sha256.Sum256(body)
+		etag = `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	}
+	w.Header().Set("ETag", etag)
+
+	if !NoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Write(append(body, '\n'))
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseETag(t *testing.T) {
+	tests := []struct {
+		tag     string
+		version int64
+		ok      bool
+	}{
+		{`"3"`, 3, true},
+		{` "12" `, 12, true},
+		{`"0"`, 0, true},
+		{`W/"3"`, 0, false},
+		{`3`, 0, false},
+		{`"three"`, 0, false},
+		{`""`, 0, false},
+		{`"`, 0, false},
+		{`*`, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.tag, func(t *testing.T) {
+			version, ok := ParseETag(test.tag)
+			if version != test.version || ok != test.ok {
+				t.Errorf("ParseETag(%q) = %d, %v, want %d, %v", test.tag, version, ok, test.version, test.ok)
+			}
+		})
+	}
+
+	if version, ok := ParseETag(ETag(42)); version != 42 || !ok {
+		t.Errorf("ParseETag(ETag(42)) = %d, %v", version, ok)
+	}
+}
+
+func TestNoneMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		want   bool
+	}{
+		{"no header", "", `"3"`, true},
+		{"same tag", `"3"`, `"3"`, false},
+		{"other tag", `"2"`, `"3"`, true},
+		{"one of tags", `"1", "3"`, `"3"`, false},
+		{"any", "*", `"3"`, false},
+		{"weak header", `W/"3"`, `"3"`, false},
+		{"weak tag", `"ab"`, `W/"ab"`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if test.header != "" {
+				r.Header.Set("If-None-Match", test.header)
+			}
+			if got := NoneMatch(r, test.etag); got != test.want {
+				t.Errorf("NoneMatch(%q, %q) = %v, want %v", test.header, test.etag, got, test.want)
+			}
+		})
+	}
+}
@@ -2,19 +2,24 @@ package utils
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bberkgulay/task-repetition-go/models"
 	"github.com/gorilla/handlers"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Headers set header to request
 func Headers(r http.Handler) http.Handler {
-	headersOk := handlers.AllowedHeaders([]string{"Authorization"})
+	headersOk := handlers.AllowedHeaders([]string{"Authorization", "If-Match", "If-None-Match"})
+	exposedOk := handlers.ExposedHeaders([]string{"ETag"})
 	originsOk := handlers.AllowedOrigins([]string{"*"})
-	methodsOk := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "OPTIONS", "DELETE"})
-	return handlers.CORS(headersOk, originsOk, methodsOk)(r)
+	methodsOk := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "OPTIONS", "DELETE"})
+	return handlers.CORS(headersOk, exposedOk, originsOk, methodsOk)(r)
 }
 
 func SendError(w http.ResponseWriter, status int, error models.Error) {
@@ -27,20 +32,45 @@ func SendSuccess(w http.ResponseWriter, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
-func HashPassword(password string) string {
-	passwordBytes := []byte(password)
-	hash, err := bcrypt.GenerateFromPassword(passwordBytes, bcrypt.DefaultCost)
+// ClientIP returns address of the client. Behind a proxy (TRUST_PROXY=true, e.g. Heroku router)
+// the last address of X-Forwarded-For is the one appended by the proxy.
+func ClientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") == "true" {
+		if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+			addresses := strings.Split(forwardedFor, ",")
+			return strings.TrimSpace(addresses[len(addresses)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return ""
+		return r.RemoteAddr
 	}
-	return string(hash[:])
+	return host
 }
 
-func CompareHashAndPassword(hashedPassword string, password string) bool {
-	hashedPasswordBytes := []byte(hashedPassword)
-	passwordBytes := []byte(password)
+// IntFromEnv returns the positive integer in the environment variable or fallback.
+func IntFromEnv(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
 
-	err := bcrypt.CompareHashAndPassword(hashedPasswordBytes, passwordBytes)
+// DurationFromEnv returns the positive duration (e.g. "15m") in the environment variable or fallback.
+func DurationFromEnv(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
 
-	return err == nil
+// StringFromEnv returns the environment variable or fallback if it is empty.
+func StringFromEnv(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
 }